@@ -1,9 +1,8 @@
 package main
 
 import (
-	"crypto/sha256"
-	"encoding/hex"
-	"fmt"
+	"context"
+	"errors"
 	"io"
 	"log"
 	"net/http"
@@ -12,8 +11,12 @@ import (
 	"strconv"
 	"strings"
 	"syscall"
+	"time"
 
+	"github.com/5tuartw/httpfromtcp/internal/accesslog"
 	"github.com/5tuartw/httpfromtcp/internal/headers"
+	"github.com/5tuartw/httpfromtcp/internal/negotiate"
+	"github.com/5tuartw/httpfromtcp/internal/ratelimit"
 	"github.com/5tuartw/httpfromtcp/internal/request"
 	"github.com/5tuartw/httpfromtcp/internal/response"
 	"github.com/5tuartw/httpfromtcp/internal/server"
@@ -21,6 +24,9 @@ import (
 
 const port = 42069
 
+// upstreamTimeout bounds each /httpbin proxy request.
+const upstreamTimeout = 30 * time.Second
+
 func main() {
 
 	handler := func(w *response.Writer, req *request.Request) {
@@ -32,27 +38,50 @@ func main() {
 		if strings.HasPrefix(target, "/httpbin") {
 			target = "https://httpbin.org" + strings.TrimPrefix(target, "/httpbin")
 
-			resp, err := http.Get(target)
+			// The upstream call is abandoned if the client goes away
+			ctx, cancel := context.WithTimeout(req.Context(), upstreamTimeout)
+			defer cancel()
+			upstream, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+			if err != nil {
+				w.WriteError(req, response.BadRequest, response.Problem{Detail: err.Error()})
+				return
+			}
+			resp, err := http.DefaultClient.Do(upstream)
 			if err != nil {
 				log.Printf("error forwarding request to %s: %v", target, err)
-				w.WriteChunkedBodyDone()
+				if req.Context().Err() != nil {
+					return
+				}
+				status := response.BadGateway
+				if errors.Is(err, context.DeadlineExceeded) {
+					status = response.GatewayTimeout
+				}
+				w.WriteError(req, status, response.Problem{Detail: "Could not reach " + target + "."})
 				return
 			}
 			defer resp.Body.Close()
 
+			// resp.Status is "200 OK"; keep the upstream phrase as sent
 			responseStatus := response.StatusCode(resp.StatusCode)
-			w.WriteStatusLine(responseStatus)
+			reason := strings.TrimPrefix(resp.Status, strconv.Itoa(resp.StatusCode)+" ")
+			err = w.WriteStatusLineReason(responseStatus, reason)
+			if err != nil {
+				log.Printf("error writing status line: %v", err)
+				return
+			}
 
 			responseHeaders := headers.HttpCopy(resp.Header)
 
-			delete(responseHeaders, "Content-Length")
-			responseHeaders["Transfer-Encoding"] = "chunked"
-			responseHeaders["Trailer"] = "X-Content-SHA256, X-Content-Length"
-			w.HasTrailers = true
+			responseHeaders.Del("Content-Length")
+			responseHeaders.Set("Transfer-Encoding", "chunked")
+			err = w.UseChecksumTrailers(response.ChecksumSHA256)
+			if err != nil {
+				log.Printf("error enabling checksum trailers: %v", err)
+				return
+			}
 
 			w.WriteHeaders(responseHeaders)
 
-			var fullBody []byte
 			buffer := make([]byte, 1024)
 			for {
 				n, err := resp.Body.Read(buffer)
@@ -62,7 +91,6 @@ func main() {
 						log.Printf("error writing chunked body: %v", errWrite)
 						return
 					}
-					fullBody = append(fullBody, buffer[:n]...)
 				}
 				if err != nil {
 					if err != io.EOF {
@@ -81,87 +109,80 @@ func main() {
 				return
 			}
 
-			hash := sha256.Sum256(fullBody)
-			hashHex := hex.EncodeToString(hash[:])
-			contentLength := len(fullBody)
-			trailers := headers.Headers{
-				"X-Content-SHA256": hashHex,
-				"X-Content-Length": strconv.Itoa(contentLength),
-			}
-			w.WriteTrailers(trailers)
-			
-
 			return
 		} else if target == "/video" {
 			responseHeaders := response.GetDefaultHeaders(0)
 
 			videoData, err := os.ReadFile("assets/vim.mp4")
 			if err != nil {
-				statusCode = response.InternalServerError
-				w.WriteStatusLine(statusCode)
-				w.WriteHeaders(responseHeaders)
-				w.WriteBody([]byte("Internal Server Error: Could not load video"))
+				w.WriteError(req, response.InternalServerError, response.Problem{
+					Detail: "Could not load video",
+				})
 			} else {
-				statusCode = response.OK
-				responseHeaders["Content-Length"] = fmt.Sprintf("%d", len(videoData))
-				responseHeaders["Content-Type"] = "video/mp4"
-				w.WriteStatusLine(statusCode)
-				w.WriteHeaders(responseHeaders)
-				w.WriteBody(videoData)
+				// Clients revalidate with the generated ETag instead of downloading again
+				responseHeaders.Set("Content-Type", "video/mp4")
+				err = w.WriteConditional(req, responseHeaders, videoData)
+				if err != nil {
+					log.Printf("error writing video: %v", err)
+				}
+			}
 
+		} else {
 
+			if target == "/yourproblem" {
+				w.WriteError(req, response.BadRequest, response.Problem{
+					Detail: "Your request honestly kinda sucked.",
+				})
+				return
+			} else if target == "/myproblem" {
+				w.WriteError(req, response.InternalServerError, response.Problem{
+					Detail: "Okay, you know what? This one is on me.",
+				})
+				return
 			}
 
-			} else {
+			// The same page is served as HTML or JSON
+			responseHeaders := response.GetDefaultHeaders(0)
+			mediaType, err := negotiate.MediaType(req.Headers, &responseHeaders, "text/html", "application/json")
+			if err != nil {
+				w.WriteError(req, response.NotAcceptable, response.Problem{
+					Detail: "This page is available as text/html or application/json.",
+				})
+				return
+			}
 
+			statusCode = response.OK
+			htmlContent = `<html>
+								<head>
+									<title>200 OK</title>
+								</head>
+								<body>
+									<h1>Success!</h1>
+									<p>Your request was an absolute banger.</p>
+								</body>
+								</html>`
+			bodyBytes := []byte(htmlContent)
+			if mediaType == "application/json" {
+				bodyBytes = []byte(`{"message":"Your request was an absolute banger."}`)
+			}
+			responseHeaders.Set("Content-Length", strconv.Itoa(len(bodyBytes)))
+			responseHeaders.Set("Content-Type", mediaType)
 
-				if target == "/yourproblem" {
-					statusCode = response.BadRequest
-					htmlContent = `<html>
-									<head>
-										<title>400 Bad Request</title>
-									</head>
-									<body>
-										<h1>Bad Request</h1>
-										<p>Your request honestly kinda sucked.</p>
-									</body>
-									</html>`
-				} else if target == "/myproblem" {
-					statusCode = response.InternalServerError
-					htmlContent = `<html>
-									<head>
-										<title>500 Internal Server Error</title>
-									</head>
-									<body>
-										<h1>Internal Server Error</h1>
-										<p>Okay, you know what? This one is on me.</p>
-									</body>
-									</html>`
-				} else {
-					statusCode = response.OK
-					htmlContent = `<html>
-									<head>
-										<title>200 OK</title>
-									</head>
-									<body>
-										<h1>Success!</h1>
-										<p>Your request was an absolute banger.</p>
-									</body>
-									</html>`
-				}
-				
-				bodyBytes := []byte(htmlContent)
-				responseHeaders := response.GetDefaultHeaders(len(bodyBytes))
-				responseHeaders = responseHeaders.Set("Content-Type", "text/html")
-
-				w.WriteStatusLine(statusCode)
-				w.WriteHeaders(responseHeaders)
-				w.WriteBody(bodyBytes)
+			w.WriteStatusLine(statusCode)
+			w.WriteHeaders(responseHeaders)
+			w.WriteBody(bodyBytes)
 		}
 
 	}
 
-	server, err := server.Serve(port, handler)
+	limiter := ratelimit.New(ratelimit.Options{Rate: 10, Burst: 20})
+
+	server, err := server.Serve(port, limiter.Handler(handler),
+		server.WithAccessLog(accesslog.Combined(os.Stdout)),
+		server.WithMetrics("/metrics"),
+		server.WithMaxConnections(1000, server.OverloadReject),
+		server.WithMaxConnectionsPerIP(100),
+	)
 	if err != nil {
 		log.Fatalf("Error starting server: %v", err)
 	}
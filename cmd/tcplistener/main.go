@@ -37,11 +37,15 @@ func main() {
 		fmt.Printf("- Target: %s\n", request.RequestLine.RequestTarget)
 		fmt.Printf("- Version: %s\n", request.RequestLine.HttpVersion)
 		fmt.Println("Headers:")
-		for key, value := range request.Headers {
-			fmt.Printf("- %s: %s\n", key, value)
+		for _, field := range request.Headers {
+			fmt.Printf("- %s: %s\n", field.Name, field.Value)
+		}
+		body, err := request.ReadBody()
+		if err != nil {
+			log.Fatalf("Unable to read request body: %v", err)
 		}
 		fmt.Println("Body:")
-		fmt.Println(string(request.Body))
+		fmt.Println(string(body))
 		fmt.Println("...")
 
 		log.Printf("Connection from %s has been closed.", connection.RemoteAddr())
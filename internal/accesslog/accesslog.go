@@ -0,0 +1,125 @@
+// Package accesslog formats one record per served request, in the Apache
+// Common or Combined Log Format or as structured log/slog records.
+package accesslog
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/5tuartw/httpfromtcp/internal/response"
+)
+
+// clfTime is the timestamp layout of the Common Log Format.
+const clfTime = "02/Jan/2006:15:04:05 -0700"
+
+// Entry describes one request and the response it got.
+type Entry struct {
+	RemoteAddr string
+	Time       time.Time
+	Method     string
+	Target     string
+	Proto      string
+	Status     response.StatusCode
+	Bytes      int
+	Duration   time.Duration
+	Referer    string
+	UserAgent  string
+}
+
+// Sink receives entries, possibly from several connections at once.
+type Sink func(e Entry)
+
+// Common writes entries to w in the Common Log Format.
+func Common(w io.Writer) Sink {
+	return lineSink(w, func(e Entry) string {
+		return e.common()
+	})
+}
+
+// Combined writes entries to w in the Combined Log Format, which adds the
+// referer and user agent to the Common Log Format.
+func Combined(w io.Writer) Sink {
+	return lineSink(w, func(e Entry) string {
+		return e.common() + " " + quote(e.Referer) + " " + quote(e.UserAgent)
+	})
+}
+
+// Slog logs entries as records of logger at info level, or to stdout as
+// JSON when logger is nil.
+func Slog(logger *slog.Logger) Sink {
+	if logger == nil {
+		logger = slog.New(slog.NewJSONHandler(os.Stdout, nil))
+	}
+	return func(e Entry) {
+		logger.LogAttrs(context.Background(), slog.LevelInfo, "request",
+			slog.String("remote_addr", e.RemoteAddr),
+			slog.String("method", e.Method),
+			slog.String("target", e.Target),
+			slog.String("proto", e.Proto),
+			slog.Int("status", int(e.Status)),
+			slog.Int("bytes", e.Bytes),
+			slog.Duration("duration", e.Duration),
+			slog.String("referer", e.Referer),
+			slog.String("user_agent", e.UserAgent),
+		)
+	}
+}
+
+func lineSink(w io.Writer, format func(e Entry) string) Sink {
+	var mu sync.Mutex
+	return func(e Entry) {
+		line := format(e) + "\n"
+		mu.Lock()
+		defer mu.Unlock()
+		io.WriteString(w, line)
+	}
+}
+
+func (e Entry) common() string {
+	host := e.RemoteAddr
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	if host == "" {
+		host = "-"
+	}
+	bytes := "-"
+	if e.Bytes > 0 {
+		bytes = strconv.Itoa(e.Bytes)
+	}
+	requestLine := e.Method + " " + e.Target + " " + e.Proto
+	return fmt.Sprintf("%s - - [%s] %s %d %s",
+		host, e.Time.Format(clfTime), quote(requestLine), int(e.Status), bytes)
+}
+
+// quote wraps a value in double quotes, escaping quotes, backslashes and
+// control characters as Apache does so a log line cannot be forged.
+func quote(value string) string {
+	if value == "" {
+		return `"-"`
+	}
+	var sb strings.Builder
+	sb.WriteByte('"')
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		switch {
+		case c == '"' || c == '\\':
+			sb.WriteByte('\\')
+			sb.WriteByte(c)
+		case c < 0x20 || c >= 0x7f:
+			fmt.Fprintf(&sb, "\\x%02x", c)
+		default:
+			sb.WriteByte(c)
+		}
+	}
+	sb.WriteByte('"')
+	return sb.String()
+}
@@ -0,0 +1,70 @@
+package accesslog
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/5tuartw/httpfromtcp/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var entry = Entry{
+	RemoteAddr: "127.0.0.1:51234",
+	Time:       time.Date(2000, 10, 10, 13, 55, 36, 0, time.FixedZone("", -7*60*60)),
+	Method:     "GET",
+	Target:     "/apache_pb.gif",
+	Proto:      "HTTP/1.0",
+	Status:     response.OK,
+	Bytes:      2326,
+	Duration:   1500 * time.Microsecond,
+	Referer:    "http://www.example.com/start.html",
+	UserAgent:  `Mozilla/4.08 "quoted"`,
+}
+
+func TestCommon(t *testing.T) {
+	// Test: the Apache documentation example
+	buf := &bytes.Buffer{}
+	Common(buf)(entry)
+	assert.Equal(t, `127.0.0.1 - - [10/Oct/2000:13:55:36 -0700] "GET /apache_pb.gif HTTP/1.0" 200 2326`+"\n", buf.String())
+
+	// Test: empty body and control characters in the target
+	buf.Reset()
+	e := entry
+	e.Bytes = 0
+	e.Target = "/a\x1b[31m"
+	Common(buf)(e)
+	assert.Equal(t, `127.0.0.1 - - [10/Oct/2000:13:55:36 -0700] "GET /a\x1b[31m HTTP/1.0" 200 -`+"\n", buf.String())
+}
+
+func TestCombined(t *testing.T) {
+	buf := &bytes.Buffer{}
+	Combined(buf)(entry)
+	assert.Equal(t, `127.0.0.1 - - [10/Oct/2000:13:55:36 -0700] "GET /apache_pb.gif HTTP/1.0" 200 2326 "http://www.example.com/start.html" "Mozilla/4.08 \"quoted\""`+"\n", buf.String())
+
+	// Test: missing referer and user agent
+	buf.Reset()
+	e := entry
+	e.Referer = ""
+	e.UserAgent = ""
+	Combined(buf)(e)
+	assert.Contains(t, buf.String(), `2326 "-" "-"`)
+}
+
+func TestSlog(t *testing.T) {
+	buf := &bytes.Buffer{}
+	Slog(slog.New(slog.NewJSONHandler(buf, nil)))(entry)
+
+	var record map[string]any
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &record))
+	assert.Equal(t, "request", record["msg"])
+	assert.Equal(t, "127.0.0.1:51234", record["remote_addr"])
+	assert.Equal(t, "/apache_pb.gif", record["target"])
+	assert.Equal(t, float64(200), record["status"])
+	assert.Equal(t, float64(2326), record["bytes"])
+	assert.Equal(t, float64(1500000), record["duration"])
+	assert.Equal(t, `Mozilla/4.08 "quoted"`, record["user_agent"])
+}
@@ -0,0 +1,151 @@
+package cookie
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/5tuartw/httpfromtcp/internal/headers"
+)
+
+type SameSite int
+
+const (
+	// SameSiteDefault leaves the attribute out and lets the browser decide.
+	SameSiteDefault SameSite = iota
+	SameSiteLax
+	SameSiteStrict
+	SameSiteNone
+)
+
+func (s SameSite) String() string {
+	switch s {
+	case SameSiteLax:
+		return "Lax"
+	case SameSiteStrict:
+		return "Strict"
+	case SameSiteNone:
+		return "None"
+	default:
+		return ""
+	}
+}
+
+// Cookie is a name/value pair received in a Cookie header or, with its
+// attributes, sent in a Set-Cookie header as described in RFC 6265.
+type Cookie struct {
+	Name  string
+	Value string
+
+	Expires time.Time
+	// MaxAge is in seconds. Zero leaves the attribute out and a negative
+	// value deletes the cookie immediately by sending Max-Age=0.
+	MaxAge      int
+	Domain      string
+	Path        string
+	Secure      bool
+	HttpOnly    bool
+	SameSite    SameSite
+	Partitioned bool
+}
+
+// Parse reads the pairs from a Cookie request header. Malformed pairs are
+// skipped, as browsers are known to send them.
+func Parse(value string) []Cookie {
+	var cookies []Cookie
+	for _, pair := range strings.Split(value, ";") {
+		name, cookieValue, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok || !headers.ValidFieldName(name) {
+			continue
+		}
+		if len(cookieValue) >= 2 && cookieValue[0] == '"' && cookieValue[len(cookieValue)-1] == '"' {
+			cookieValue = cookieValue[1 : len(cookieValue)-1]
+		}
+		if !validValue(cookieValue) {
+			continue
+		}
+		cookies = append(cookies, Cookie{Name: name, Value: cookieValue})
+	}
+	return cookies
+}
+
+// Validate checks the cookie can be sent without corrupting the Set-Cookie
+// line and will not be rejected by browsers.
+func (c *Cookie) Validate() error {
+	if !headers.ValidFieldName(c.Name) {
+		return fmt.Errorf("invalid cookie name: %q", c.Name)
+	}
+	if !validValue(c.Value) {
+		return fmt.Errorf("invalid value for cookie %s: %q", c.Name, c.Value)
+	}
+	if !validAttribute(c.Domain) {
+		return fmt.Errorf("invalid domain for cookie %s: %q", c.Name, c.Domain)
+	}
+	if !validAttribute(c.Path) {
+		return fmt.Errorf("invalid path for cookie %s: %q", c.Name, c.Path)
+	}
+	if c.SameSite == SameSiteNone && !c.Secure {
+		return errors.New("cookie with SameSite=None must be Secure")
+	}
+	if c.Partitioned && !c.Secure {
+		return errors.New("partitioned cookie must be Secure")
+	}
+	return nil
+}
+
+// String formats the cookie as a Set-Cookie field value.
+func (c *Cookie) String() string {
+	var sb strings.Builder
+	sb.WriteString(c.Name + "=" + c.Value)
+	if !c.Expires.IsZero() {
+		sb.WriteString("; Expires=" + headers.FormatTime(c.Expires))
+	}
+	if c.MaxAge > 0 {
+		sb.WriteString("; Max-Age=" + strconv.Itoa(c.MaxAge))
+	} else if c.MaxAge < 0 {
+		sb.WriteString("; Max-Age=0")
+	}
+	if c.Domain != "" {
+		sb.WriteString("; Domain=" + c.Domain)
+	}
+	if c.Path != "" {
+		sb.WriteString("; Path=" + c.Path)
+	}
+	if c.Secure {
+		sb.WriteString("; Secure")
+	}
+	if c.HttpOnly {
+		sb.WriteString("; HttpOnly")
+	}
+	if c.SameSite != SameSiteDefault {
+		sb.WriteString("; SameSite=" + c.SameSite.String())
+	}
+	if c.Partitioned {
+		sb.WriteString("; Partitioned")
+	}
+	return sb.String()
+}
+
+// validValue allows the cookie-octet range: visible ASCII except DQUOTE,
+// comma, semicolon and backslash.
+func validValue(value string) bool {
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		if c < 0x21 || c > 0x7e || c == '"' || c == ',' || c == ';' || c == '\\' {
+			return false
+		}
+	}
+	return true
+}
+
+func validAttribute(value string) bool {
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		if c < 0x20 || c == 0x7f || c == ';' {
+			return false
+		}
+	}
+	return true
+}
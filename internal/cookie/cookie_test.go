@@ -0,0 +1,65 @@
+package cookie
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParse(t *testing.T) {
+	// Test: Several pairs, quoted value
+	cookies := Parse(`session=abc123; theme="dark"; empty=`)
+	assert.Equal(t, []Cookie{
+		{Name: "session", Value: "abc123"},
+		{Name: "theme", Value: "dark"},
+		{Name: "empty", Value: ""},
+	}, cookies)
+
+	// Test: Malformed pairs are skipped
+	cookies = Parse(`novalue; bad name=1; ok=1; bad=a,b`)
+	assert.Equal(t, []Cookie{{Name: "ok", Value: "1"}}, cookies)
+
+	// Test: Empty header
+	assert.Nil(t, Parse(""))
+}
+
+func TestString(t *testing.T) {
+	// Test: Every attribute
+	c := &Cookie{
+		Name:        "session",
+		Value:       "abc123",
+		Expires:     time.Date(2030, time.January, 2, 3, 4, 5, 0, time.UTC),
+		MaxAge:      3600,
+		Domain:      "example.com",
+		Path:        "/",
+		Secure:      true,
+		HttpOnly:    true,
+		SameSite:    SameSiteStrict,
+		Partitioned: true,
+	}
+	require.NoError(t, c.Validate())
+	assert.Equal(t, "session=abc123; Expires=Wed, 02 Jan 2030 03:04:05 GMT; Max-Age=3600; Domain=example.com; Path=/; Secure; HttpOnly; SameSite=Strict; Partitioned", c.String())
+
+	// Test: Deleting a cookie
+	c = &Cookie{Name: "session", MaxAge: -1}
+	assert.Equal(t, "session=; Max-Age=0", c.String())
+}
+
+func TestValidate(t *testing.T) {
+	invalid := []*Cookie{
+		{Name: "", Value: "a"},
+		{Name: "a b", Value: "a"},
+		{Name: "a", Value: "x;y"},
+		{Name: "a", Value: "x\r\nSet-Cookie: y=z"},
+		{Name: "a", Value: "x", Path: "/; Secure"},
+		{Name: "a", Value: "x", Domain: "example.com\n"},
+		{Name: "a", Value: "x", SameSite: SameSiteNone},
+		{Name: "a", Value: "x", Partitioned: true},
+	}
+	for _, c := range invalid {
+		assert.Error(t, c.Validate(), c.String())
+	}
+	assert.NoError(t, (&Cookie{Name: "a", Value: "x", SameSite: SameSiteNone, Secure: true}).Validate())
+}
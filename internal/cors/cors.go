@@ -0,0 +1,174 @@
+// Package cors answers CORS preflight requests and adds the
+// Access-Control-* fields to actual responses for allowed origins.
+package cors
+
+import (
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/5tuartw/httpfromtcp/internal/headers"
+	"github.com/5tuartw/httpfromtcp/internal/request"
+	"github.com/5tuartw/httpfromtcp/internal/response"
+	"github.com/5tuartw/httpfromtcp/internal/server"
+)
+
+// Options configure which cross-origin requests are allowed. Zero fields
+// fall back to the defaults noted on each.
+type Options struct {
+	// AllowedOrigins are exact origins such as "https://app.example.com",
+	// patterns with one wildcard such as "https://*.example.com", or "*"
+	// for any origin.
+	AllowedOrigins []string
+	// AllowOriginFunc, if set, is asked about origins the list rejects.
+	AllowOriginFunc func(origin string) bool
+	// AllowedMethods defaults to GET, HEAD and POST.
+	AllowedMethods []string
+	// AllowedHeaders are request fields a preflight may ask for; "*"
+	// allows any.
+	AllowedHeaders []string
+	// ExposedHeaders are response fields scripts may read.
+	ExposedHeaders []string
+	// MaxAge is how long a browser may cache the preflight result. Zero
+	// leaves it to the browser.
+	MaxAge           time.Duration
+	AllowCredentials bool
+}
+
+var defaultMethods = []string{"GET", "HEAD", "POST"}
+
+// Handler wraps next so that preflight requests are answered here and actual
+// requests from allowed origins carry the CORS response fields. Requests
+// without an Origin go straight to next, with Vary: Origin unless every
+// origin gets the same answer.
+func Handler(next server.Handler, opts Options) server.Handler {
+	if len(opts.AllowedMethods) == 0 {
+		opts.AllowedMethods = defaultMethods
+	}
+	return func(w *response.Writer, req *request.Request) {
+		origin := req.Headers.Get("Origin")
+		preflight := req.RequestLine.Method == "OPTIONS" && req.Headers.Has("Access-Control-Request-Method")
+		h := w.Header()
+		if opts.varyOrigin() {
+			// Caches must not reuse a response across origins, with or without one
+			h.AddVary("Origin")
+		}
+		if origin == "" {
+			next(w, req)
+			return
+		}
+
+		if preflight {
+			h.AddVary("Access-Control-Request-Method")
+			h.AddVary("Access-Control-Request-Headers")
+			opts.preflight(w, req, origin)
+			return
+		}
+
+		if opts.allowOrigin(origin) {
+			opts.setOrigin(h, origin)
+			if len(opts.ExposedHeaders) > 0 {
+				h.Set("Access-Control-Expose-Headers", strings.Join(opts.ExposedHeaders, ", "))
+			}
+		}
+		next(w, req)
+	}
+}
+
+func (o Options) preflight(w *response.Writer, req *request.Request, origin string) {
+	method := req.Headers.Get("Access-Control-Request-Method")
+	requested := splitList(req.Headers.Get("Access-Control-Request-Headers"))
+
+	switch {
+	case !o.allowOrigin(origin):
+		o.reject(w, req, "Origin "+origin+" is not allowed.")
+		return
+	case !slices.Contains(o.AllowedMethods, method):
+		o.reject(w, req, "Method "+method+" is not allowed.")
+		return
+	}
+	anyHeader := slices.Contains(o.AllowedHeaders, "*")
+	for _, name := range requested {
+		if !anyHeader && !containsFold(o.AllowedHeaders, name) {
+			o.reject(w, req, "Header "+name+" is not allowed.")
+			return
+		}
+	}
+
+	h := w.Header()
+	o.setOrigin(h, origin)
+	h.Set("Access-Control-Allow-Methods", strings.Join(o.AllowedMethods, ", "))
+	if len(requested) > 0 {
+		// Echoing the request is the only form of "*" that works with credentials
+		h.Set("Access-Control-Allow-Headers", strings.Join(requested, ", "))
+	}
+	if o.MaxAge > 0 {
+		h.Set("Access-Control-Max-Age", strconv.Itoa(int(o.MaxAge.Seconds())))
+	}
+	w.WriteStatusLine(response.NoContent)
+	w.WriteHeaders(nil)
+}
+
+func (o Options) reject(w *response.Writer, req *request.Request, detail string) {
+	w.WriteError(req, response.Forbidden, response.Problem{Detail: "CORS preflight failed. " + detail})
+}
+
+// setOrigin answers with "*" only when any origin is allowed and no
+// credentials are involved, since browsers refuse "*" with credentials.
+func (o Options) setOrigin(h *headers.Headers, origin string) {
+	if slices.Contains(o.AllowedOrigins, "*") && !o.AllowCredentials {
+		h.Set("Access-Control-Allow-Origin", "*")
+	} else {
+		h.Set("Access-Control-Allow-Origin", origin)
+	}
+	if o.AllowCredentials {
+		h.Set("Access-Control-Allow-Credentials", "true")
+	}
+}
+
+// varyOrigin reports whether responses depend on the Origin, which is all
+// but the case of "*" sent to every origin.
+func (o Options) varyOrigin() bool {
+	return !slices.Contains(o.AllowedOrigins, "*") || o.AllowCredentials
+}
+
+func (o Options) allowOrigin(origin string) bool {
+	for _, allowed := range o.AllowedOrigins {
+		if matchOrigin(allowed, origin) {
+			return true
+		}
+	}
+	return o.AllowOriginFunc != nil && o.AllowOriginFunc(origin)
+}
+
+func matchOrigin(pattern, origin string) bool {
+	origin = strings.ToLower(origin)
+	pattern = strings.ToLower(pattern)
+	prefix, suffix, wildcard := strings.Cut(pattern, "*")
+	if !wildcard {
+		return pattern == origin
+	}
+	return len(origin) > len(prefix)+len(suffix) &&
+		strings.HasPrefix(origin, prefix) && strings.HasSuffix(origin, suffix)
+}
+
+func splitList(value string) []string {
+	var list []string
+	for _, element := range strings.Split(value, ",") {
+		element = strings.TrimSpace(element)
+		if element != "" {
+			list = append(list, element)
+		}
+	}
+	return list
+}
+
+func containsFold(list []string, value string) bool {
+	for _, element := range list {
+		if strings.EqualFold(element, value) {
+			return true
+		}
+	}
+	return false
+}
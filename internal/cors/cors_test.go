@@ -0,0 +1,87 @@
+package cors
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/5tuartw/httpfromtcp/internal/request"
+	"github.com/5tuartw/httpfromtcp/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func serve(t *testing.T, opts Options, raw string) string {
+	t.Helper()
+	req, err := request.RequestFromReader(strings.NewReader(raw))
+	require.NoError(t, err)
+	buf := &bytes.Buffer{}
+	w := &response.Writer{IoWriter: buf}
+	Handler(func(w *response.Writer, req *request.Request) {
+		w.WriteStatusLine(response.OK)
+		w.WriteHeaders(response.GetDefaultHeaders(2))
+		w.WriteBody([]byte("ok"))
+	}, opts)(w, req)
+	return buf.String()
+}
+
+func TestPreflight(t *testing.T) {
+	opts := Options{
+		AllowedOrigins:   []string{"https://*.example.com"},
+		AllowedMethods:   []string{"GET", "PUT"},
+		AllowedHeaders:   []string{"Content-Type", "X-Token"},
+		MaxAge:           10 * time.Minute,
+		AllowCredentials: true,
+	}
+
+	// Test: allowed preflight echoes the origin and requested headers
+	out := serve(t, opts, "OPTIONS /items HTTP/1.1\r\nHost: api\r\nOrigin: https://app.example.com\r\n"+
+		"Access-Control-Request-Method: PUT\r\nAccess-Control-Request-Headers: x-token, content-type\r\n\r\n")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 204 No Content\r\n"), out)
+	assert.Contains(t, out, "Access-Control-Allow-Origin: https://app.example.com\r\n")
+	assert.Contains(t, out, "Access-Control-Allow-Credentials: true\r\n")
+	assert.Contains(t, out, "Access-Control-Allow-Methods: GET, PUT\r\n")
+	assert.Contains(t, out, "Access-Control-Allow-Headers: x-token, content-type\r\n")
+	assert.Contains(t, out, "Access-Control-Max-Age: 600\r\n")
+	assert.Contains(t, out, "Vary: Origin, Access-Control-Request-Method, Access-Control-Request-Headers\r\n")
+	assert.True(t, strings.HasSuffix(out, "\r\n\r\n"), out)
+
+	// Test: origin, method and header are each checked
+	out = serve(t, opts, "OPTIONS /items HTTP/1.1\r\nHost: api\r\nOrigin: https://example.com.evil\r\nAccess-Control-Request-Method: PUT\r\n\r\n")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 403 Forbidden\r\n"), out)
+	assert.NotContains(t, out, "Access-Control-Allow-Origin")
+	out = serve(t, opts, "OPTIONS /items HTTP/1.1\r\nHost: api\r\nOrigin: https://app.example.com\r\nAccess-Control-Request-Method: DELETE\r\n\r\n")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 403 Forbidden\r\n"), out)
+	out = serve(t, opts, "OPTIONS /items HTTP/1.1\r\nHost: api\r\nOrigin: https://app.example.com\r\nAccess-Control-Request-Method: GET\r\nAccess-Control-Request-Headers: X-Other\r\n\r\n")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 403 Forbidden\r\n"), out)
+}
+
+func TestActualRequest(t *testing.T) {
+	// Test: any origin without credentials gets "*" and exposed headers
+	opts := Options{AllowedOrigins: []string{"*"}, ExposedHeaders: []string{"X-Total"}}
+	out := serve(t, opts, "GET /items HTTP/1.1\r\nHost: api\r\nOrigin: https://anywhere.test\r\n\r\n")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 200 OK\r\n"), out)
+	assert.Contains(t, out, "Access-Control-Allow-Origin: *\r\n")
+	assert.Contains(t, out, "Access-Control-Expose-Headers: X-Total\r\n")
+	assert.NotContains(t, out, "Vary")
+	assert.True(t, strings.HasSuffix(out, "ok"))
+
+	// Test: rejected origin still reaches the handler but gets no CORS fields
+	opts = Options{AllowOriginFunc: func(origin string) bool { return origin == "https://partner.test" }}
+	out = serve(t, opts, "GET /items HTTP/1.1\r\nHost: api\r\nOrigin: https://other.test\r\n\r\n")
+	assert.NotContains(t, out, "Access-Control-Allow-Origin")
+	assert.Contains(t, out, "Vary: Origin\r\n")
+	out = serve(t, opts, "GET /items HTTP/1.1\r\nHost: api\r\nOrigin: https://partner.test\r\n\r\n")
+	assert.Contains(t, out, "Access-Control-Allow-Origin: https://partner.test\r\n")
+
+	// Test: no Origin, no CORS fields, but still Vary for an allow-list
+	out = serve(t, opts, "OPTIONS /items HTTP/1.1\r\nHost: api\r\n\r\n")
+	assert.NotContains(t, out, "Access-Control-")
+	assert.Contains(t, out, "Vary: Origin\r\n")
+
+	// Test: and none when every origin gets "*"
+	opts = Options{AllowedOrigins: []string{"*"}}
+	out = serve(t, opts, "GET /items HTTP/1.1\r\nHost: api\r\n\r\n")
+	assert.NotContains(t, out, "Vary")
+}
@@ -0,0 +1,229 @@
+// Package forwarded finds the original client address, scheme and host of a
+// request that passed through proxies, from the RFC 7239 Forwarded field or
+// the X-Forwarded-For, X-Forwarded-Proto and X-Forwarded-Host fields.
+package forwarded
+
+import (
+	"net"
+	"net/netip"
+	"strings"
+
+	"github.com/5tuartw/httpfromtcp/internal/request"
+	"github.com/5tuartw/httpfromtcp/internal/response"
+	"github.com/5tuartw/httpfromtcp/internal/server"
+)
+
+// Resolver trusts forwarding fields only as far as they were added by the
+// Trusted proxies. Walking from the connection's peer towards the client,
+// the first address outside Trusted is taken as the client, so a client
+// cannot spoof its address by sending the fields itself. Only the fields
+// selected by Header are read; the proxies must overwrite or append to those,
+// while anything else the client sent is passed through untouched.
+type Resolver struct {
+	Trusted []netip.Prefix
+	Header  Header
+}
+
+// Header selects which fields the trusted proxies set.
+type Header int
+
+const (
+	// XForwardedFor reads X-Forwarded-For, X-Forwarded-Proto and
+	// X-Forwarded-Host, as set by nginx and most load balancers.
+	XForwardedFor Header = iota
+	// Forwarded reads the RFC 7239 Forwarded field.
+	Forwarded
+)
+
+// Result is the effective client IP, scheme and host. Fields the proxies did
+// not report keep the request's own values.
+type Result struct {
+	ClientAddr string
+	Scheme     string
+	Host       string
+}
+
+// hop is one proxy's record of the client it received the request from.
+type hop struct {
+	forAddr netip.Addr
+	// known is false for "unknown" and obfuscated identifiers.
+	known bool
+	proto string
+	host  string
+}
+
+// Resolve works out the effective values for req without changing it.
+func (r *Resolver) Resolve(req *request.Request) Result {
+	result := Result{ClientAddr: req.ClientAddr, Scheme: req.Scheme, Host: req.Host}
+
+	peer, ok := parseAddr(req.RemoteAddr)
+	if !ok || !r.trusted(peer) {
+		return result
+	}
+
+	var hops []hop
+	switch r.Header {
+	case Forwarded:
+		hops, ok = parseForwarded(req.Headers.Get("Forwarded"))
+	default:
+		hops, ok = parseXForwarded(req)
+	}
+	if !ok {
+		return result
+	}
+
+	// Each hop was added by the proxy one step nearer the server, starting
+	// with the peer
+	for i := len(hops) - 1; i >= 0; i-- {
+		h := hops[i]
+		if h.proto != "" {
+			result.Scheme = h.proto
+		}
+		if h.host != "" {
+			result.Host = h.host
+		}
+		if !h.known {
+			break
+		}
+		result.ClientAddr = h.forAddr.String()
+		if !r.trusted(h.forAddr) {
+			break
+		}
+	}
+	return result
+}
+
+// Handler wraps next so that requests carry the resolved client address,
+// scheme and host.
+func (r *Resolver) Handler(next server.Handler) server.Handler {
+	return func(w *response.Writer, req *request.Request) {
+		result := r.Resolve(req)
+		req.ClientAddr = result.ClientAddr
+		req.Scheme = result.Scheme
+		req.Host = result.Host
+		next(w, req)
+	}
+}
+
+func (r *Resolver) trusted(addr netip.Addr) bool {
+	for _, prefix := range r.Trusted {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseForwarded reads Forwarded elements such as
+// `for=192.0.2.60;proto=http;by=203.0.113.43, for="[2001:db8::1]:4711"`.
+func parseForwarded(value string) ([]hop, bool) {
+	var hops []hop
+	for _, element := range splitQuoted(value, ',') {
+		h := hop{}
+		hasFor := false
+		for _, pair := range splitQuoted(element, ';') {
+			name, pairValue, found := strings.Cut(pair, "=")
+			if !found {
+				return nil, false
+			}
+			pairValue = unquote(strings.TrimSpace(pairValue))
+			switch strings.ToLower(strings.TrimSpace(name)) {
+			case "for":
+				hasFor = true
+				h.forAddr, h.known = parseAddr(pairValue)
+			case "proto":
+				h.proto = strings.ToLower(pairValue)
+			case "host":
+				h.host = pairValue
+			}
+		}
+		if !hasFor {
+			return nil, false
+		}
+		hops = append(hops, h)
+	}
+	return hops, len(hops) > 0
+}
+
+// parseXForwarded lines X-Forwarded-Proto and X-Forwarded-Host values up
+// with X-Forwarded-For from the right, as each proxy appends to all three.
+func parseXForwarded(req *request.Request) ([]hop, bool) {
+	forList := splitQuoted(req.Headers.Get("X-Forwarded-For"), ',')
+	if len(forList) == 0 {
+		return nil, false
+	}
+	protoList := splitQuoted(req.Headers.Get("X-Forwarded-Proto"), ',')
+	hostList := splitQuoted(req.Headers.Get("X-Forwarded-Host"), ',')
+
+	hops := make([]hop, len(forList))
+	for i, forValue := range forList {
+		hops[i].forAddr, hops[i].known = parseAddr(forValue)
+		fromRight := len(forList) - 1 - i
+		if j := len(protoList) - 1 - fromRight; j >= 0 {
+			hops[i].proto = strings.ToLower(protoList[j])
+		}
+		if j := len(hostList) - 1 - fromRight; j >= 0 {
+			hops[i].host = hostList[j]
+		}
+	}
+	return hops, true
+}
+
+// parseAddr accepts an IP with or without a port, IPv6 in brackets when it
+// has one.
+func parseAddr(value string) (netip.Addr, bool) {
+	if host, _, err := net.SplitHostPort(value); err == nil {
+		value = host
+	}
+	value = strings.TrimSuffix(strings.TrimPrefix(value, "["), "]")
+	addr, err := netip.ParseAddr(value)
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	return addr.Unmap(), true
+}
+
+// splitQuoted splits on sep outside quoted strings, trimming elements and
+// dropping empty ones.
+func splitQuoted(value string, sep byte) []string {
+	var parts []string
+	start := 0
+	quoted := false
+	for i := 0; i <= len(value); i++ {
+		if i < len(value) {
+			switch value[i] {
+			case '"':
+				quoted = !quoted
+				continue
+			case '\\':
+				if quoted {
+					i++
+				}
+				continue
+			}
+			if value[i] != sep || quoted {
+				continue
+			}
+		}
+		part := strings.TrimSpace(value[start:i])
+		if part != "" {
+			parts = append(parts, part)
+		}
+		start = i + 1
+	}
+	return parts
+}
+
+func unquote(value string) string {
+	if len(value) < 2 || value[0] != '"' || value[len(value)-1] != '"' {
+		return value
+	}
+	var sb strings.Builder
+	for i := 1; i < len(value)-1; i++ {
+		if value[i] == '\\' && i+1 < len(value)-1 {
+			i++
+		}
+		sb.WriteByte(value[i])
+	}
+	return sb.String()
+}
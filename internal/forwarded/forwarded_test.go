@@ -0,0 +1,89 @@
+package forwarded
+
+import (
+	"net/netip"
+	"strings"
+	"testing"
+
+	"github.com/5tuartw/httpfromtcp/internal/request"
+	"github.com/5tuartw/httpfromtcp/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newRequest(t *testing.T, remoteAddr string, fields ...string) *request.Request {
+	t.Helper()
+	raw := "GET / HTTP/1.1\r\nHost: internal:8080\r\n" + strings.Join(fields, "\r\n")
+	if len(fields) > 0 {
+		raw += "\r\n"
+	}
+	req, err := request.RequestFromReader(strings.NewReader(raw + "\r\n"))
+	require.NoError(t, err)
+	req.RemoteAddr = remoteAddr
+	req.ClientAddr, _, _ = strings.Cut(remoteAddr, ":")
+	req.Scheme = "http"
+	req.Host = req.Headers.Get("Host")
+	return req
+}
+
+var trusted = []netip.Prefix{
+	netip.MustParsePrefix("10.0.0.0/8"),
+	netip.MustParsePrefix("2001:db8:ffff::/48"),
+}
+
+var resolver = &Resolver{Trusted: trusted}
+
+func TestForwarded(t *testing.T) {
+	resolver := &Resolver{Trusted: trusted, Header: Forwarded}
+
+	// Test: one trusted proxy
+	req := newRequest(t, "10.0.0.1:5000", `Forwarded: for=192.0.2.60;proto=https;host=example.com`)
+	assert.Equal(t, Result{ClientAddr: "192.0.2.60", Scheme: "https", Host: "example.com"}, resolver.Resolve(req))
+
+	// Test: the chain is walked through trusted proxies only, so the
+	// client's own element is ignored
+	req = newRequest(t, "10.0.0.1:5000",
+		`Forwarded: for=198.51.100.1;proto=http, for=203.0.113.9;proto=https, for="[2001:db8:ffff::2]:4711"`)
+	assert.Equal(t, Result{ClientAddr: "203.0.113.9", Scheme: "https", Host: "internal:8080"}, resolver.Resolve(req))
+
+	// Test: an unknown client leaves the last known proxy
+	req = newRequest(t, "10.0.0.1:5000", `Forwarded: for=unknown, for=10.0.0.2`)
+	assert.Equal(t, "10.0.0.2", resolver.Resolve(req).ClientAddr)
+
+	// Test: malformed fields are ignored
+	req = newRequest(t, "10.0.0.1:5000", `Forwarded: proto=https`)
+	assert.Equal(t, Result{ClientAddr: "10.0.0.1", Scheme: "http", Host: "internal:8080"}, resolver.Resolve(req))
+}
+
+func TestXForwarded(t *testing.T) {
+	// Test: values line up from the right
+	req := newRequest(t, "10.0.0.1:5000",
+		"X-Forwarded-For: 1.1.1.1, 192.0.2.60, 10.0.0.7",
+		"X-Forwarded-Proto: https, http",
+		"X-Forwarded-Host: example.com")
+	assert.Equal(t, Result{ClientAddr: "192.0.2.60", Scheme: "https", Host: "example.com"}, resolver.Resolve(req))
+
+	// Test: a Forwarded field from the client is ignored next to the
+	// proxy's X-Forwarded-For
+	req = newRequest(t, "10.0.0.1:5000", "Forwarded: for=1.2.3.4;proto=https", "X-Forwarded-For: 198.51.100.9")
+	assert.Equal(t, Result{ClientAddr: "198.51.100.9", Scheme: "http", Host: "internal:8080"}, resolver.Resolve(req))
+
+	// Test: and the other way round when the proxy sets Forwarded
+	forwarded := &Resolver{Trusted: trusted, Header: Forwarded}
+	req = newRequest(t, "10.0.0.1:5000", "Forwarded: for=192.0.2.1", "X-Forwarded-For: 1.2.3.4")
+	assert.Equal(t, "192.0.2.1", forwarded.Resolve(req).ClientAddr)
+	req = newRequest(t, "10.0.0.1:5000", "X-Forwarded-For: 1.2.3.4")
+	assert.Equal(t, "10.0.0.1", forwarded.Resolve(req).ClientAddr)
+}
+
+func TestUntrustedPeer(t *testing.T) {
+	// Test: a client talking to us directly cannot spoof anything
+	req := newRequest(t, "192.0.2.99:5000", "X-Forwarded-For: 10.0.0.5", "X-Forwarded-Proto: https")
+	assert.Equal(t, Result{ClientAddr: "192.0.2.99", Scheme: "http", Host: "internal:8080"}, resolver.Resolve(req))
+
+	// Test: the handler updates the request
+	req = newRequest(t, "10.0.0.1:5000", "X-Forwarded-For: 192.0.2.60", "X-Forwarded-Proto: https")
+	resolver.Handler(func(w *response.Writer, req *request.Request) {})(nil, req)
+	assert.Equal(t, "192.0.2.60", req.ClientAddr)
+	assert.Equal(t, "https", req.Scheme)
+}
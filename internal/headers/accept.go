@@ -0,0 +1,95 @@
+package headers
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// QualityValue is one element of an Accept-style list.
+type QualityValue struct {
+	// Value is the media range, coding, charset or language range, lowercased.
+	Value string
+	// Params holds media type parameters other than q, for Accept.
+	Params map[string]string
+	Q      float64
+}
+
+// ParseQualityList parses a list such as "text/html, application/json;q=0.9"
+// and returns its elements by descending q-value, keeping the sent order for
+// equal weights.
+func ParseQualityList(value string) ([]QualityValue, error) {
+	var list []QualityValue
+	for _, element := range splitList(value) {
+		if element == "" {
+			continue
+		}
+		parts := strings.Split(element, ";")
+		qv := QualityValue{
+			Value: strings.ToLower(strings.TrimSpace(parts[0])),
+			Q:     1,
+		}
+		if qv.Value == "" {
+			return nil, fmt.Errorf("invalid list element: %q", element)
+		}
+		for _, param := range parts[1:] {
+			name, paramValue, ok := strings.Cut(strings.TrimSpace(param), "=")
+			if !ok {
+				return nil, fmt.Errorf("invalid parameter in %q", element)
+			}
+			name = strings.ToLower(strings.TrimSpace(name))
+			paramValue = strings.TrimSpace(paramValue)
+			if name == "q" {
+				q, err := parseQValue(paramValue)
+				if err != nil {
+					return nil, err
+				}
+				qv.Q = q
+				continue
+			}
+			if qv.Params == nil {
+				qv.Params = map[string]string{}
+			}
+			qv.Params[name] = unquote(paramValue)
+		}
+		list = append(list, qv)
+	}
+
+	sort.SliceStable(list, func(i, j int) bool {
+		return list[i].Q > list[j].Q
+	})
+	return list, nil
+}
+
+// parseQValue accepts the qvalue grammar: 0 or 1 with up to three decimals.
+func parseQValue(value string) (float64, error) {
+	valid := len(value) > 0 && len(value) <= 5 && (value[0] == '0' || value[0] == '1')
+	if len(value) > 1 {
+		valid = valid && value[1] == '.'
+		for _, c := range value[2:] {
+			valid = valid && c >= '0' && c <= '9'
+		}
+	}
+	q, err := strconv.ParseFloat(value, 64)
+	if !valid || err != nil || q > 1 {
+		return 0, fmt.Errorf("invalid q-value: %q", value)
+	}
+	return q, nil
+}
+
+func (h Headers) Accept() ([]QualityValue, error) {
+	return ParseQualityList(h.Get("Accept"))
+}
+
+func (h Headers) AcceptCharset() ([]QualityValue, error) {
+	return ParseQualityList(h.Get("Accept-Charset"))
+}
+
+func (h Headers) AcceptEncoding() ([]QualityValue, error) {
+	return ParseQualityList(h.Get("Accept-Encoding"))
+}
+
+func (h Headers) AcceptLanguage() ([]QualityValue, error) {
+	return ParseQualityList(h.Get("Accept-Language"))
+}
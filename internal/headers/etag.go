@@ -0,0 +1,72 @@
+package headers
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ETag is an entity tag; Tag is the opaque value without its quotes.
+type ETag struct {
+	Tag  string
+	Weak bool
+}
+
+func (e ETag) String() string {
+	if e.Weak {
+		return `W/"` + e.Tag + `"`
+	}
+	return `"` + e.Tag + `"`
+}
+
+// StrongMatch is the comparison used by If-Match and If-Range: both tags
+// must be strong and identical.
+func (e ETag) StrongMatch(other ETag) bool {
+	return !e.Weak && !other.Weak && e.Tag == other.Tag
+}
+
+// WeakMatch is the comparison used by If-None-Match, ignoring weakness.
+func (e ETag) WeakMatch(other ETag) bool {
+	return e.Tag == other.Tag
+}
+
+func ParseETag(value string) (ETag, error) {
+	etag := ETag{}
+	if strings.HasPrefix(value, "W/") {
+		etag.Weak = true
+		value = value[2:]
+	}
+	if len(value) < 2 || value[0] != '"' || value[len(value)-1] != '"' {
+		return ETag{}, fmt.Errorf("invalid entity tag: %q", value)
+	}
+	etag.Tag = value[1 : len(value)-1]
+	for i := 0; i < len(etag.Tag); i++ {
+		c := etag.Tag[i]
+		if c == '"' || c < 0x21 || c == 0x7f {
+			return ETag{}, fmt.Errorf("invalid entity tag: %q", value)
+		}
+	}
+	return etag, nil
+}
+
+// ETags parses a field such as If-Match or If-None-Match. any is true for
+// "*"; otherwise the listed tags are returned.
+func (h Headers) ETags(key string) (tags []ETag, any bool, err error) {
+	for _, element := range splitList(h.Get(key)) {
+		if element == "" {
+			continue
+		}
+		if element == "*" {
+			any = true
+			continue
+		}
+		etag, err := ParseETag(element)
+		if err != nil {
+			return nil, false, err
+		}
+		tags = append(tags, etag)
+	}
+	if any && len(tags) > 0 {
+		return nil, false, fmt.Errorf("invalid %s: \"*\" combined with entity tags", key)
+	}
+	return tags, any, nil
+}
@@ -2,9 +2,11 @@ package headers
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"net/http"
 	"regexp"
+	"sort"
 	"strings"
 )
 
@@ -12,17 +14,87 @@ const crlf = "\r\n"
 
 var validPattern = regexp.MustCompile("^[a-zA-Z0-9!#$%&'*+.^_|~`-]*$")
 
-type Headers map[string]string
+var (
+	ErrInvalidFieldName  = errors.New("invalid field name")
+	ErrInvalidFieldValue = errors.New("invalid field value")
+)
+
+// Field is a single field line, with the name in the case it was received
+// or set in.
+type Field struct {
+	Name  string
+	Value string
+}
 
+// Headers holds field lines in the order they were parsed or added. Names
+// are matched case-insensitively but written out as given, and repeated
+// fields are kept as separate lines.
+type Headers []Field
+
+// Get returns every value for key combined into one comma-separated list, or
+// "" if the field is absent. Use Values for fields such as Set-Cookie that
+// cannot be combined.
 func (h Headers) Get(key string) string {
-	return h[strings.ToLower(key)]
+	return strings.Join(h.Values(key), ", ")
 }
 
-func (h *Headers) Parse(data []byte) (int, bool, error) {
+// Values returns the value of each field line named key, in order.
+func (h Headers) Values(key string) []string {
+	var values []string
+	for _, field := range h {
+		if strings.EqualFold(field.Name, key) {
+			values = append(values, field.Value)
+		}
+	}
+	return values
+}
+
+// Has reports whether at least one field line is named key.
+func (h Headers) Has(key string) bool {
+	for _, field := range h {
+		if strings.EqualFold(field.Name, key) {
+			return true
+		}
+	}
+	return false
+}
+
+// Add appends a field line, keeping any existing ones with the same name.
+func (h *Headers) Add(key, value string) {
+	*h = append(*h, Field{Name: key, Value: value})
+}
+
+// Set replaces all field lines named key with one line holding value, in
+// the position of the first one, or appends it if there was none.
+func (h *Headers) Set(key, value string) {
+	newHeaders := (*h)[:0:0]
+	set := false
+	for _, field := range *h {
+		if !strings.EqualFold(field.Name, key) {
+			newHeaders = append(newHeaders, field)
+		} else if !set {
+			newHeaders = append(newHeaders, Field{Name: key, Value: value})
+			set = true
+		}
+	}
+	if !set {
+		newHeaders = append(newHeaders, Field{Name: key, Value: value})
+	}
+	*h = newHeaders
+}
 
-	if *h == nil {
-		*h = make(Headers)
+// Del removes all field lines named key.
+func (h *Headers) Del(key string) {
+	newHeaders := (*h)[:0:0]
+	for _, field := range *h {
+		if !strings.EqualFold(field.Name, key) {
+			newHeaders = append(newHeaders, field)
+		}
 	}
+	*h = newHeaders
+}
+
+func (h *Headers) Parse(data []byte) (int, bool, error) {
 
 	if len(data) == 0 {
 		return 0, false, fmt.Errorf("no data to parse")
@@ -33,6 +105,10 @@ func (h *Headers) Parse(data []byte) (int, bool, error) {
 	for {
 		index := bytes.Index(data, []byte(crlf))
 		if index == -1 {
+			// Any LF seen before a CRLF is a bare line ending
+			if bytes.IndexByte(data, '\n') != -1 {
+				return bytesConsumed, false, fmt.Errorf("invalid header format: bare LF line ending")
+			}
 			//log.Printf("No CRLF found in data")
 			return bytesConsumed, false, nil
 		}
@@ -43,57 +119,90 @@ func (h *Headers) Parse(data []byte) (int, bool, error) {
 		}
 
 		line := data[:index]
+		if bytes.ContainsAny(line, "\r\n\x00") {
+			return bytesConsumed, false, fmt.Errorf("invalid header format: CR, LF or NUL in field line")
+		}
+		if line[0] == ' ' || line[0] == '\t' {
+			return bytesConsumed, false, fmt.Errorf("invalid header format: obsolete line folding not allowed")
+		}
 		parts := strings.SplitN(string(line), ":", 2)
 		if len(parts) != 2 {
 			return bytesConsumed, false, fmt.Errorf("invalid header format: missing or multiple colons")
 		}
-		if strings.HasSuffix(parts[0], " ") {
+		key := parts[0]
+		if strings.HasSuffix(key, " ") || strings.HasSuffix(key, "\t") {
 			return bytesConsumed, false, fmt.Errorf("invalid header format: whitespace before colon")
 		}
-		key := strings.TrimSpace(parts[0])
 		if len(key) < 1 {
 			return bytesConsumed, false, fmt.Errorf("invalid header format: field name must have at least one character")
 		}
-		if !validFieldName(key) {
+		if !ValidFieldName(key) {
 			return bytesConsumed, false, fmt.Errorf("invalid header format: character in field name not permitted")
 		}
-		key = strings.ToLower(key)
-		value := strings.TrimSpace(parts[1])
-
-		_, ok := (*h)[key]
-		if !ok {
-			(*h)[key] = value
-		} else {
-			(*h)[key] = (*h)[key] + ", " + value
-		}
+		value := strings.Trim(parts[1], " \t")
+		h.Add(key, value)
 
 		data = data[index+2:]
 		bytesConsumed += index + 2
 	}
 }
 
-func validFieldName(str string) bool {
-	return validPattern.MatchString(str)
+// ValidFieldName reports whether str is a non-empty token, the grammar
+// RFC 9110 requires of field names.
+func ValidFieldName(str string) bool {
+	return str != "" && validPattern.MatchString(str)
 }
 
-func (h Headers) Set(key, value string) Headers {
-	newHeaders := h.Clone()
-	newHeaders[key] = value
-	return newHeaders
+// ValidFieldValue reports whether str can be sent as a field value: no
+// control characters other than horizontal tab, so in particular no CR, LF
+// or NUL that could end the line early.
+func ValidFieldValue(str string) bool {
+	for i := 0; i < len(str); i++ {
+		c := str[i]
+		if (c < ' ' && c != '\t') || c == 0x7f {
+			return false
+		}
+	}
+	return true
+}
+
+// Validate checks every field line before it is written, so that values
+// taken from user input cannot split a message.
+func (h Headers) Validate() error {
+	for _, field := range h {
+		if !ValidFieldName(field.Name) {
+			return fmt.Errorf("%w: %q", ErrInvalidFieldName, field.Name)
+		}
+		if !ValidFieldValue(field.Value) {
+			return fmt.Errorf("%w for %s: %q contains a control character", ErrInvalidFieldValue, field.Name, field.Value)
+		}
+	}
+	return nil
 }
 
 func (h Headers) Clone() Headers {
-	newHeaders := make(Headers)
-	for key, value := range h {
-		newHeaders[key] = value
+	if h == nil {
+		return nil
 	}
+	newHeaders := make(Headers, len(h))
+	copy(newHeaders, h)
 	return newHeaders
 }
 
+// HttpCopy converts net/http headers, sorted by name so the result does not
+// depend on map iteration order.
 func HttpCopy(httpH http.Header) Headers {
+	keys := make([]string, 0, len(httpH))
+	for k := range httpH {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
 	h := Headers{}
-	for k, v := range httpH {
-		h.Set(k, strings.Join(v, ","))
+	for _, k := range keys {
+		for _, v := range httpH[k] {
+			h.Add(k, v)
+		}
 	}
 	return h
 }
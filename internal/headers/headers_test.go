@@ -9,17 +9,17 @@ import (
 
 func TestHeaderParse(t *testing.T) {
 	// Test: Valid single header
-	headers := make(Headers)
+	headers := Headers{}
 	data := []byte("Host: localhost:42069\r\n\r\n")
 	n, done, err := headers.Parse(data)
 	require.NoError(t, err)
 	require.NotNil(t, headers)
-	assert.Equal(t, "localhost:42069", headers["host"])
+	assert.Equal(t, "localhost:42069", headers.Get("host"))
 	assert.Equal(t, 25, n)
 	assert.True(t, done)
 
 	// Test: Invalid spacing header
-	headers = make(Headers)
+	headers = Headers{}
 	data = []byte("       Host : localhost:42069       \r\n\r\n")
 	n, done, err = headers.Parse(data)
 	require.Error(t, err)
@@ -27,63 +27,164 @@ func TestHeaderParse(t *testing.T) {
 	assert.False(t, done)
 
 	// Test: Valid single header with extra whitespace
-	headers = make(Headers)
-	data = []byte("      Host:       localhost:42069    \r\n\r\n")
+	headers = Headers{}
+	data = []byte("Host:       localhost:42069    \r\n\r\n")
 	n, done, err = headers.Parse(data)
 	require.NoError(t, err)
 	require.NotNil(t, headers)
-	assert.Equal(t, "localhost:42069", headers["host"])
-	assert.Equal(t, 41, n)
+	assert.Equal(t, "localhost:42069", headers.Get("host"))
+	assert.Equal(t, 35, n)
 	assert.True(t, done)
 
+	// Test: Leading whitespace is obsolete line folding
+	headers = Headers{}
+	data = []byte("      Host:       localhost:42069    \r\n\r\n")
+	n, done, err = headers.Parse(data)
+	require.Error(t, err)
+	assert.Equal(t, 0, n)
+	assert.False(t, done)
+
 	// Test: Valid 2 headers with existing headers
-	headers = make(Headers)
-	headers["Test"] = "Valuable"
+	headers = Headers{}
+	headers.Add("Test", "Valuable")
 	data = []byte("Host: localhost:42069\r\n\r\n")
 	n, done, err = headers.Parse(data)
 	require.NoError(t, err)
 	require.NotNil(t, headers)
-	assert.Equal(t, "localhost:42069", headers["host"])
+	assert.Equal(t, "localhost:42069", headers.Get("host"))
 	assert.Equal(t, 25, n)
 	assert.Equal(t, 2, len(headers))
 	assert.True(t, done)
 
 	// Test: Capital letters in field name
-	headers = make(Headers)
+	headers = Headers{}
 	data = []byte("hoST: localhost:42069\r\n\r\n")
 	n, done, err = headers.Parse(data)
 	require.NoError(t, err)
 	require.NotNil(t, headers)
-	assert.Equal(t, "localhost:42069", headers["host"])
+	assert.Equal(t, "localhost:42069", headers.Get("host"))
 	assert.Equal(t, 25, n)
 	assert.True(t, done)
 
 	// Test: special characters allowed
-	headers = make(Headers)
+	headers = Headers{}
 	data = []byte("h!o`s|t: localhost:42069\r\n\r\n")
 	n, done, err = headers.Parse(data)
 	require.NoError(t, err)
 	require.NotNil(t, headers)
-	assert.Equal(t, "localhost:42069", headers["h!o`s|t"])
+	assert.Equal(t, "localhost:42069", headers.Get("h!o`s|t"))
 	assert.Equal(t, 28, n)
 	assert.True(t, done)
 
 	// Test: special characters not allowed
-	headers = make(Headers)
+	headers = Headers{}
 	data = []byte("H©st: localhost:42069\r\n\r\n")
 	_, done, err = headers.Parse(data)
 	require.Error(t, err)
 	assert.False(t, done)
 
 	// Test: multiple of same field-name
-	headers = make(Headers)
+	headers = Headers{}
 	data = []byte("Set-Person: lane-loves-go\r\nSet-Person: prime-loves-zig\r\nSet-Person: tj-loves-ocaml\r\n\r\n")
 	n, done, err = headers.Parse(data)
 	require.NoError(t, err)
 	require.NotNil(t, headers)
-	assert.Equal(t, "lane-loves-go, prime-loves-zig, tj-loves-ocaml", headers["set-person"])
+	assert.Equal(t, "lane-loves-go, prime-loves-zig, tj-loves-ocaml", headers.Get("set-person"))
 	assert.Equal(t, 86, n)
 	assert.True(t, done)
 
+	// Test: obs-fold continuation line
+	headers = Headers{}
+	data = []byte("X-Folded: first\r\n\tsecond\r\n\r\n")
+	_, done, err = headers.Parse(data)
+	require.Error(t, err)
+	assert.False(t, done)
+
+	// Test: tab before colon
+	headers = Headers{}
+	data = []byte("Host\t: localhost:42069\r\n\r\n")
+	_, done, err = headers.Parse(data)
+	require.Error(t, err)
+	assert.False(t, done)
+
+	// Test: bare LF line ending
+	headers = Headers{}
+	data = []byte("Host: localhost:42069\nX-Smuggled: yes\r\n\r\n")
+	_, done, err = headers.Parse(data)
+	require.Error(t, err)
+	assert.False(t, done)
+
+	// Test: bare LF with no CRLF yet
+	headers = Headers{}
+	data = []byte("Host: localhost:42069\n\n")
+	_, done, err = headers.Parse(data)
+	require.Error(t, err)
+	assert.False(t, done)
+
+
+}
+
+func TestHeaderFields(t *testing.T) {
+	// Test: Parse keeps order, casing and repeated lines
+	headers := Headers{}
+	data := []byte("Set-Cookie: a=1\r\nContent-Type: text/plain\r\nset-cookie: b=2\r\n\r\n")
+	_, done, err := headers.Parse(data)
+	require.NoError(t, err)
+	assert.True(t, done)
+	assert.Equal(t, Headers{
+		{Name: "Set-Cookie", Value: "a=1"},
+		{Name: "Content-Type", Value: "text/plain"},
+		{Name: "set-cookie", Value: "b=2"},
+	}, headers)
+	assert.Equal(t, []string{"a=1", "b=2"}, headers.Values("SET-COOKIE"))
+	assert.Equal(t, "a=1, b=2", headers.Get("Set-Cookie"))
+	assert.True(t, headers.Has("content-type"))
+	assert.False(t, headers.Has("Content-Length"))
+	assert.Nil(t, headers.Values("Content-Length"))
+
+	// Test: Set replaces in place and drops repeats
+	headers.Set("SET-COOKIE", "c=3")
+	assert.Equal(t, Headers{
+		{Name: "SET-COOKIE", Value: "c=3"},
+		{Name: "Content-Type", Value: "text/plain"},
+	}, headers)
+
+	// Test: Set appends a new field, Add keeps existing ones
+	headers.Set("Content-Length", "0")
+	headers.Add("Set-Cookie", "d=4")
+	assert.Equal(t, []string{"c=3", "d=4"}, headers.Values("set-cookie"))
+	assert.Equal(t, "Content-Length", headers[2].Name)
+
+	// Test: Del removes every line
+	headers.Del("set-cookie")
+	assert.Equal(t, Headers{
+		{Name: "Content-Type", Value: "text/plain"},
+		{Name: "Content-Length", Value: "0"},
+	}, headers)
+
+	// Test: Clone does not share storage
+	clone := headers.Clone()
+	clone.Set("Content-Type", "text/html")
+	assert.Equal(t, "text/plain", headers.Get("Content-Type"))
+}
+
+func TestHeaderValidate(t *testing.T) {
+	// Test: Valid fields
+	headers := Headers{{Name: "X-Echo", Value: "hello\tworld"}, {Name: "h!o`s|t", Value: ""}}
+	require.NoError(t, headers.Validate())
+
+	// Test: CRLF injection in value
+	headers = Headers{{Name: "X-Echo", Value: "a\r\nSet-Cookie: admin=1"}}
+	err := headers.Validate()
+	require.ErrorIs(t, err, ErrInvalidFieldValue)
+	assert.Contains(t, err.Error(), "X-Echo")
+
+	// Test: NUL and DEL in value
+	assert.False(t, ValidFieldValue("a\x00b"))
+	assert.False(t, ValidFieldValue("a\x7fb"))
 
+	// Test: Invalid field names
+	require.ErrorIs(t, Headers{{Name: "X Echo", Value: "a"}}.Validate(), ErrInvalidFieldName)
+	require.ErrorIs(t, Headers{{Name: "", Value: "a"}}.Validate(), ErrInvalidFieldName)
+	require.ErrorIs(t, Headers{{Name: "X-Echo\r\n", Value: "a"}}.Validate(), ErrInvalidFieldName)
 }
@@ -0,0 +1,191 @@
+package headers
+
+import (
+	"errors"
+	"fmt"
+	"mime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// TimeFormat is the IMF-fixdate format that all generated dates must use.
+const TimeFormat = "Mon, 02 Jan 2006 15:04:05 GMT"
+
+// Obsolete date formats recipients must still accept, see RFC 9110 section 5.6.7.
+const (
+	rfc850Format  = "Monday, 02-Jan-06 15:04:05 GMT"
+	asctimeFormat = "Mon Jan _2 15:04:05 2006"
+)
+
+// ContentLength returns the Content-Length as a non-negative number, or -1 if
+// the field is absent. Repeated fields are accepted only when they agree.
+func (h Headers) ContentLength() (int64, error) {
+	values := h.Values("Content-Length")
+	if len(values) == 0 {
+		return -1, nil
+	}
+
+	length := int64(-1)
+	for _, part := range splitList(strings.Join(values, ",")) {
+		if part == "" {
+			return 0, errors.New("invalid Content-Length: empty value")
+		}
+		for _, c := range part {
+			if c < '0' || c > '9' {
+				return 0, fmt.Errorf("invalid Content-Length: %q", part)
+			}
+		}
+		n, err := strconv.ParseInt(part, 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid Content-Length: %v", err)
+		}
+		if length != -1 && n != length {
+			return 0, fmt.Errorf("conflicting Content-Length values: %s", strings.Join(values, ", "))
+		}
+		length = n
+	}
+	if length == -1 {
+		return 0, errors.New("invalid Content-Length: empty value")
+	}
+	return length, nil
+}
+
+// ContentType returns the lowercased media type, e.g. "multipart/form-data",
+// and its parameters such as charset or boundary. Both are empty if the field
+// is absent.
+func (h Headers) ContentType() (string, map[string]string, error) {
+	value := h.Get("Content-Type")
+	if value == "" {
+		return "", nil, nil
+	}
+	mediaType, params, err := mime.ParseMediaType(value)
+	if err != nil {
+		return "", nil, fmt.Errorf("invalid Content-Type: %v", err)
+	}
+	return mediaType, params, nil
+}
+
+// CacheControl maps lowercased directive names to their unquoted arguments,
+// with "" for directives such as no-store that take none.
+type CacheControl map[string]string
+
+func (h Headers) CacheControl() CacheControl {
+	directives := CacheControl{}
+	for _, part := range splitList(h.Get("Cache-Control")) {
+		name, value, _ := strings.Cut(part, "=")
+		name = strings.ToLower(strings.TrimSpace(name))
+		if !ValidFieldName(name) {
+			continue
+		}
+		directives[name] = unquote(strings.TrimSpace(value))
+	}
+	return directives
+}
+
+func (c CacheControl) Has(directive string) bool {
+	_, ok := c[strings.ToLower(directive)]
+	return ok
+}
+
+// Seconds returns the argument of a delta-seconds directive such as max-age
+// or s-maxage.
+func (c CacheControl) Seconds(directive string) (time.Duration, bool) {
+	value, ok := c[strings.ToLower(directive)]
+	if !ok || value == "" {
+		return 0, false
+	}
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || n < 0 {
+		return 0, false
+	}
+	return time.Duration(n) * time.Second, true
+}
+
+// ParseTime parses an HTTP-date in IMF-fixdate or either obsolete format.
+func ParseTime(value string) (time.Time, error) {
+	for _, layout := range []string{TimeFormat, rfc850Format, asctimeFormat} {
+		t, err := time.Parse(layout, value)
+		if err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid HTTP-date: %q", value)
+}
+
+// FormatTime formats t as an IMF-fixdate.
+func FormatTime(t time.Time) string {
+	return t.UTC().Format(TimeFormat)
+}
+
+// Time returns the date held in a field such as Date, Last-Modified or
+// If-Modified-Since, and false if the field is absent or not a valid date.
+func (h Headers) Time(key string) (time.Time, bool) {
+	values := h.Values(key)
+	if len(values) != 1 {
+		return time.Time{}, false
+	}
+	t, err := ParseTime(values[0])
+	if err != nil {
+		return time.Time{}, false
+	}
+	return t, true
+}
+
+// AddVary adds field to the Vary header unless it is already listed or the
+// response already varies on everything.
+func (h *Headers) AddVary(field string) {
+	existing := h.Get("Vary")
+	for _, listed := range splitList(existing) {
+		if listed == "*" || strings.EqualFold(listed, field) {
+			return
+		}
+	}
+	if existing == "" {
+		h.Set("Vary", field)
+		return
+	}
+	h.Set("Vary", existing+", "+field)
+}
+
+// splitList splits a comma-separated field value into trimmed elements,
+// ignoring commas inside quoted strings.
+func splitList(value string) []string {
+	var parts []string
+	start := 0
+	quoted := false
+	for i := 0; i < len(value); i++ {
+		switch value[i] {
+		case '"':
+			quoted = !quoted
+		case '\\':
+			if quoted {
+				i++
+			}
+		case ',':
+			if !quoted {
+				parts = append(parts, strings.Trim(value[start:i], " \t"))
+				start = i + 1
+			}
+		}
+	}
+	last := strings.Trim(value[start:], " \t")
+	if last != "" || len(parts) > 0 {
+		parts = append(parts, last)
+	}
+	return parts
+}
+
+func unquote(value string) string {
+	if len(value) < 2 || value[0] != '"' || value[len(value)-1] != '"' {
+		return value
+	}
+	var sb strings.Builder
+	for i := 1; i < len(value)-1; i++ {
+		if value[i] == '\\' && i+1 < len(value)-1 {
+			i++
+		}
+		sb.WriteByte(value[i])
+	}
+	return sb.String()
+}
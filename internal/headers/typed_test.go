@@ -0,0 +1,136 @@
+package headers
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestContentLength(t *testing.T) {
+	// Test: Absent
+	n, err := Headers{}.ContentLength()
+	require.NoError(t, err)
+	assert.Equal(t, int64(-1), n)
+
+	// Test: Valid and agreeing duplicates
+	n, err = Headers{{Name: "Content-Length", Value: "42"}, {Name: "content-length", Value: "42"}}.ContentLength()
+	require.NoError(t, err)
+	assert.Equal(t, int64(42), n)
+
+	// Test: Invalid values
+	for _, value := range []string{"", "-1", "+1", "1.5", "0x10", "42, 43", "99999999999999999999"} {
+		_, err = Headers{{Name: "Content-Length", Value: value}}.ContentLength()
+		assert.Error(t, err, value)
+	}
+}
+
+func TestContentType(t *testing.T) {
+	mediaType, params, err := Headers{{Name: "Content-Type", Value: `Multipart/Form-Data; boundary="abc def"; charset=UTF-8`}}.ContentType()
+	require.NoError(t, err)
+	assert.Equal(t, "multipart/form-data", mediaType)
+	assert.Equal(t, map[string]string{"boundary": "abc def", "charset": "UTF-8"}, params)
+
+	mediaType, params, err = Headers{}.ContentType()
+	require.NoError(t, err)
+	assert.Equal(t, "", mediaType)
+	assert.Nil(t, params)
+
+	_, _, err = Headers{{Name: "Content-Type", Value: "text/"}}.ContentType()
+	require.Error(t, err)
+}
+
+func TestQualityLists(t *testing.T) {
+	// Test: Sorted by q, ties keep order
+	h := Headers{{Name: "Accept", Value: `text/html;level=1, application/json;q=0.9, text/*;q=0.9, */*;q=0.1`}}
+	list, err := h.Accept()
+	require.NoError(t, err)
+	require.Len(t, list, 4)
+	assert.Equal(t, "text/html", list[0].Value)
+	assert.Equal(t, map[string]string{"level": "1"}, list[0].Params)
+	assert.Equal(t, 1.0, list[0].Q)
+	assert.Equal(t, "application/json", list[1].Value)
+	assert.Equal(t, "text/*", list[2].Value)
+	assert.Equal(t, 0.1, list[3].Q)
+
+	// Test: Language and encoding
+	h = Headers{{Name: "Accept-Language", Value: "en-GB;q=0.8, fr, en;q=0.7"}, {Name: "Accept-Encoding", Value: "gzip;q=0, br"}}
+	list, err = h.AcceptLanguage()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"fr", "en-gb", "en"}, []string{list[0].Value, list[1].Value, list[2].Value})
+	list, err = h.AcceptEncoding()
+	require.NoError(t, err)
+	assert.Equal(t, "br", list[0].Value)
+	assert.Equal(t, 0.0, list[1].Q)
+
+	// Test: Invalid q-values
+	for _, value := range []string{"gzip;q=2", "gzip;q=0.1234", "gzip;q=abc", "gzip;q", "gzip;q=-1"} {
+		_, err = ParseQualityList(value)
+		assert.Error(t, err, value)
+	}
+}
+
+func TestCacheControl(t *testing.T) {
+	cc := Headers{{Name: "Cache-Control", Value: `no-cache="Set-Cookie, X-Foo", Max-Age=60, must-revalidate`}}.CacheControl()
+	assert.True(t, cc.Has("no-cache"))
+	assert.Equal(t, "Set-Cookie, X-Foo", cc["no-cache"])
+	assert.True(t, cc.Has("must-revalidate"))
+	maxAge, ok := cc.Seconds("max-age")
+	assert.True(t, ok)
+	assert.Equal(t, 60*time.Second, maxAge)
+	_, ok = cc.Seconds("s-maxage")
+	assert.False(t, ok)
+}
+
+func TestHTTPDates(t *testing.T) {
+	want := time.Date(1994, time.November, 6, 8, 49, 37, 0, time.UTC)
+	for _, value := range []string{
+		"Sun, 06 Nov 1994 08:49:37 GMT",
+		"Sunday, 06-Nov-94 08:49:37 GMT",
+		"Sun Nov  6 08:49:37 1994",
+	} {
+		got, err := ParseTime(value)
+		require.NoError(t, err, value)
+		assert.True(t, want.Equal(got), value)
+	}
+	assert.Equal(t, "Sun, 06 Nov 1994 08:49:37 GMT", FormatTime(want))
+
+	got, ok := Headers{{Name: "Last-Modified", Value: "Sun, 06 Nov 1994 08:49:37 GMT"}}.Time("last-modified")
+	assert.True(t, ok)
+	assert.True(t, want.Equal(got))
+	_, ok = Headers{{Name: "Last-Modified", Value: "yesterday"}}.Time("Last-Modified")
+	assert.False(t, ok)
+}
+
+func TestETags(t *testing.T) {
+	tags, any, err := Headers{{Name: "If-None-Match", Value: `"abc", W/"def", "g,h"`}}.ETags("If-None-Match")
+	require.NoError(t, err)
+	assert.False(t, any)
+	assert.Equal(t, []ETag{{Tag: "abc"}, {Tag: "def", Weak: true}, {Tag: "g,h"}}, tags)
+	assert.Equal(t, `W/"def"`, tags[1].String())
+	assert.True(t, tags[0].StrongMatch(ETag{Tag: "abc"}))
+	assert.False(t, tags[1].StrongMatch(ETag{Tag: "def"}))
+	assert.True(t, tags[1].WeakMatch(ETag{Tag: "def"}))
+
+	_, any, err = Headers{{Name: "If-Match", Value: "*"}}.ETags("If-Match")
+	require.NoError(t, err)
+	assert.True(t, any)
+
+	_, _, err = Headers{{Name: "If-Match", Value: "abc"}}.ETags("If-Match")
+	require.Error(t, err)
+}
+
+func TestAddVary(t *testing.T) {
+	// Test: fields are appended once, case-insensitively
+	h := Headers{}
+	h.AddVary("Accept")
+	h.AddVary("Origin")
+	h.AddVary("accept")
+	assert.Equal(t, "Accept, Origin", h.Get("Vary"))
+
+	// Test: "*" already covers everything
+	h = Headers{{Name: "Vary", Value: "*"}}
+	h.AddVary("Accept")
+	assert.Equal(t, "*", h.Get("Vary"))
+}
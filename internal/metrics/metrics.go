@@ -0,0 +1,245 @@
+// Package metrics provides counters, gauges and histograms that render in
+// the Prometheus text exposition format.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// ContentType is the media type of WriteText's output.
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// DefaultBuckets are upper bounds in seconds suited to request latencies.
+var DefaultBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+type metric interface {
+	write(sb *strings.Builder)
+}
+
+// Registry holds metrics and writes them in the order they were created.
+type Registry struct {
+	mu      sync.Mutex
+	metrics []metric
+	names   map[string]bool
+}
+
+func NewRegistry() *Registry {
+	return &Registry{names: map[string]bool{}}
+}
+
+func (r *Registry) register(name string, m metric) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.names[name] {
+		panic(fmt.Sprintf("metrics: %s registered twice", name))
+	}
+	r.names[name] = true
+	r.metrics = append(r.metrics, m)
+}
+
+// WriteText writes every metric in the Prometheus text format.
+func (r *Registry) WriteText(w io.Writer) error {
+	r.mu.Lock()
+	metrics := append([]metric(nil), r.metrics...)
+	r.mu.Unlock()
+
+	var sb strings.Builder
+	for _, m := range metrics {
+		m.write(&sb)
+	}
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
+
+// Counter only goes up, such as a number of requests served.
+type Counter struct {
+	name, help string
+	value      atomic.Uint64
+}
+
+func (r *Registry) NewCounter(name, help string) *Counter {
+	c := &Counter{name: name, help: help}
+	r.register(name, c)
+	return c
+}
+
+func (c *Counter) Inc()          { c.value.Add(1) }
+func (c *Counter) Add(n uint64)  { c.value.Add(n) }
+func (c *Counter) Value() uint64 { return c.value.Load() }
+
+func (c *Counter) write(sb *strings.Builder) {
+	writeHeader(sb, c.name, c.help, "counter")
+	writeSample(sb, c.name, "", float64(c.Value()))
+}
+
+// CounterVec is a family of counters split by the values of its labels.
+type CounterVec struct {
+	name, help string
+	labels     []string
+
+	mu       sync.Mutex
+	counters map[string]*Counter
+}
+
+func (r *Registry) NewCounterVec(name, help string, labels ...string) *CounterVec {
+	v := &CounterVec{name: name, help: help, labels: labels, counters: map[string]*Counter{}}
+	r.register(name, v)
+	return v
+}
+
+// With returns the counter for the label values, given in the order the
+// labels were declared.
+func (v *CounterVec) With(values ...string) *Counter {
+	if len(values) != len(v.labels) {
+		panic(fmt.Sprintf("metrics: %s takes %d label values, got %d", v.name, len(v.labels), len(values)))
+	}
+	key := formatLabels(v.labels, values)
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	c, ok := v.counters[key]
+	if !ok {
+		c = &Counter{}
+		v.counters[key] = c
+	}
+	return c
+}
+
+func (v *CounterVec) write(sb *strings.Builder) {
+	v.mu.Lock()
+	keys := make([]string, 0, len(v.counters))
+	for key := range v.counters {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	values := make([]uint64, len(keys))
+	for i, key := range keys {
+		values[i] = v.counters[key].Value()
+	}
+	v.mu.Unlock()
+
+	writeHeader(sb, v.name, v.help, "counter")
+	for i, key := range keys {
+		writeSample(sb, v.name, key, float64(values[i]))
+	}
+}
+
+// Gauge goes up and down, such as a number of open connections.
+type Gauge struct {
+	name, help string
+	value      atomic.Int64
+}
+
+func (r *Registry) NewGauge(name, help string) *Gauge {
+	g := &Gauge{name: name, help: help}
+	r.register(name, g)
+	return g
+}
+
+func (g *Gauge) Inc()         { g.value.Add(1) }
+func (g *Gauge) Dec()         { g.value.Add(-1) }
+func (g *Gauge) Add(n int64)  { g.value.Add(n) }
+func (g *Gauge) Set(n int64)  { g.value.Store(n) }
+func (g *Gauge) Value() int64 { return g.value.Load() }
+
+func (g *Gauge) write(sb *strings.Builder) {
+	writeHeader(sb, g.name, g.help, "gauge")
+	writeSample(sb, g.name, "", float64(g.Value()))
+}
+
+// Histogram counts observations into cumulative buckets, such as request
+// durations in seconds.
+type Histogram struct {
+	name, help string
+	buckets    []float64
+
+	mu     sync.Mutex
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+// NewHistogram creates a histogram with the given bucket upper bounds, or
+// DefaultBuckets if none are given. The +Inf bucket is implied.
+func (r *Registry) NewHistogram(name, help string, buckets ...float64) *Histogram {
+	if len(buckets) == 0 {
+		buckets = DefaultBuckets
+	}
+	buckets = append([]float64(nil), buckets...)
+	sort.Float64s(buckets)
+	h := &Histogram{name: name, help: help, buckets: buckets, counts: make([]uint64, len(buckets))}
+	r.register(name, h)
+	return h
+}
+
+func (h *Histogram) Observe(value float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for i, bound := range h.buckets {
+		if value <= bound {
+			h.counts[i]++
+		}
+	}
+	h.sum += value
+	h.count++
+}
+
+func (h *Histogram) write(sb *strings.Builder) {
+	h.mu.Lock()
+	counts := append([]uint64(nil), h.counts...)
+	sum, count := h.sum, h.count
+	h.mu.Unlock()
+
+	writeHeader(sb, h.name, h.help, "histogram")
+	for i, bound := range h.buckets {
+		writeSample(sb, h.name+"_bucket", formatLabels([]string{"le"}, []string{formatFloat(bound)}), float64(counts[i]))
+	}
+	writeSample(sb, h.name+"_bucket", `le="+Inf"`, float64(count))
+	writeSample(sb, h.name+"_sum", "", sum)
+	writeSample(sb, h.name+"_count", "", float64(count))
+}
+
+func writeHeader(sb *strings.Builder, name, help, kind string) {
+	if help != "" {
+		help = strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(help)
+		sb.WriteString("# HELP " + name + " " + help + "\n")
+	}
+	sb.WriteString("# TYPE " + name + " " + kind + "\n")
+}
+
+func writeSample(sb *strings.Builder, name, labels string, value float64) {
+	sb.WriteString(name)
+	if labels != "" {
+		sb.WriteString("{" + labels + "}")
+	}
+	sb.WriteString(" " + formatFloat(value) + "\n")
+}
+
+// formatLabels renders name="value" pairs with the value escaping of the
+// text format.
+func formatLabels(names, values []string) string {
+	escape := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	pairs := make([]string, len(names))
+	for i, name := range names {
+		pairs[i] = name + `="` + escape.Replace(values[i]) + `"`
+	}
+	return strings.Join(pairs, ",")
+}
+
+func formatFloat(value float64) string {
+	switch {
+	case math.IsInf(value, 1):
+		return "+Inf"
+	case math.IsInf(value, -1):
+		return "-Inf"
+	case math.IsNaN(value):
+		return "NaN"
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
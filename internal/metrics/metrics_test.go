@@ -0,0 +1,55 @@
+package metrics
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteText(t *testing.T) {
+	r := NewRegistry()
+	requests := r.NewCounterVec("requests_total", "Requests by class.", "class")
+	active := r.NewGauge("connections_active", "Open\nconnections.")
+	latency := r.NewHistogram("latency_seconds", "", 0.5, 0.1)
+	r.NewCounter("errors_total", "Errors.")
+
+	requests.With("2xx").Add(3)
+	requests.With("4xx").Inc()
+	requests.With(`a"b`).Inc()
+	active.Inc()
+	active.Inc()
+	active.Dec()
+	latency.Observe(0.05)
+	latency.Observe(0.3)
+	latency.Observe(2)
+
+	sb := &strings.Builder{}
+	require.NoError(t, r.WriteText(sb))
+	assert.Equal(t, `# HELP requests_total Requests by class.
+# TYPE requests_total counter
+requests_total{class="2xx"} 3
+requests_total{class="4xx"} 1
+requests_total{class="a\"b"} 1
+# HELP connections_active Open\nconnections.
+# TYPE connections_active gauge
+connections_active 1
+# TYPE latency_seconds histogram
+latency_seconds_bucket{le="0.1"} 1
+latency_seconds_bucket{le="0.5"} 2
+latency_seconds_bucket{le="+Inf"} 3
+latency_seconds_sum 2.35
+latency_seconds_count 3
+# HELP errors_total Errors.
+# TYPE errors_total counter
+errors_total 0
+`, sb.String())
+}
+
+func TestRegistryMisuse(t *testing.T) {
+	r := NewRegistry()
+	v := r.NewCounterVec("a_total", "", "method", "class")
+	assert.Panics(t, func() { r.NewGauge("a_total", "") })
+	assert.Panics(t, func() { v.With("GET") })
+}
@@ -0,0 +1,121 @@
+// Package negotiate picks the representation to send from those a handler
+// offers, following the proactive negotiation rules of RFC 9110 section 12.
+package negotiate
+
+import (
+	"errors"
+	"mime"
+	"strings"
+
+	"github.com/5tuartw/httpfromtcp/internal/headers"
+)
+
+// ErrNotAcceptable means none of the offers is acceptable to the client and
+// the handler should answer 406 Not Acceptable or send a default anyway.
+var ErrNotAcceptable = errors.New("no acceptable representation")
+
+// MediaType returns the offer, such as "text/html" or "application/json",
+// that best matches the request's Accept field. Each offer takes the q-value
+// of the most specific media range matching it, and ties go to the earlier
+// offer. Vary: Accept is added to resp.
+func MediaType(req headers.Headers, resp *headers.Headers, offers ...string) (string, error) {
+	return negotiate(req, resp, "Accept", offers, matchMediaType)
+}
+
+// Language returns the offered language tag that best matches
+// Accept-Language, using the basic filtering of RFC 4647 so that "en" also
+// matches "en-GB". Vary: Accept-Language is added to resp.
+func Language(req headers.Headers, resp *headers.Headers, offers ...string) (string, error) {
+	return negotiate(req, resp, "Accept-Language", offers, matchLanguage)
+}
+
+// Charset returns the offered charset that best matches Accept-Charset.
+// Vary: Accept-Charset is added to resp.
+func Charset(req headers.Headers, resp *headers.Headers, offers ...string) (string, error) {
+	return negotiate(req, resp, "Accept-Charset", offers, matchCharset)
+}
+
+// matcher reports whether a list element matches an offer and how specific
+// the match is; the most specific matching element decides the offer's q.
+type matcher func(element headers.QualityValue, offer string) (specificity int, ok bool)
+
+func negotiate(req headers.Headers, resp *headers.Headers, field string, offers []string, match matcher) (string, error) {
+	if resp != nil {
+		resp.AddVary(field)
+	}
+	if len(offers) == 0 {
+		return "", ErrNotAcceptable
+	}
+
+	// A missing, empty or unparseable field accepts anything
+	accepted, err := headers.ParseQualityList(req.Get(field))
+	if err != nil || len(accepted) == 0 {
+		return offers[0], nil
+	}
+
+	best := ""
+	bestQ := 0.0
+	for _, offer := range offers {
+		q := 0.0
+		mostSpecific := -1
+		for _, element := range accepted {
+			specificity, ok := match(element, offer)
+			if ok && specificity > mostSpecific {
+				mostSpecific = specificity
+				q = element.Q
+			}
+		}
+		if q > bestQ {
+			best = offer
+			bestQ = q
+		}
+	}
+	if best == "" {
+		return "", ErrNotAcceptable
+	}
+	return best, nil
+}
+
+func matchMediaType(element headers.QualityValue, offer string) (int, bool) {
+	mediaType, params, err := mime.ParseMediaType(offer)
+	if err != nil {
+		return 0, false
+	}
+	offerType, offerSubtype, _ := strings.Cut(mediaType, "/")
+	rangeType, rangeSubtype, _ := strings.Cut(element.Value, "/")
+
+	switch {
+	case rangeType == "*" && rangeSubtype == "*":
+		return 0, true
+	case rangeType != offerType:
+		return 0, false
+	case rangeSubtype == "*":
+		return 1, true
+	case rangeSubtype != offerSubtype:
+		return 0, false
+	}
+	for name, value := range element.Params {
+		if !strings.EqualFold(params[name], value) {
+			return 0, false
+		}
+	}
+	return 2 + len(element.Params), true
+}
+
+func matchLanguage(element headers.QualityValue, offer string) (int, bool) {
+	if element.Value == "*" {
+		return 0, true
+	}
+	offer = strings.ToLower(offer)
+	if offer == element.Value || strings.HasPrefix(offer, element.Value+"-") {
+		return len(element.Value), true
+	}
+	return 0, false
+}
+
+func matchCharset(element headers.QualityValue, offer string) (int, bool) {
+	if element.Value == "*" {
+		return 0, true
+	}
+	return 1, strings.EqualFold(element.Value, offer)
+}
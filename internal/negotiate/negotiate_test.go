@@ -0,0 +1,82 @@
+package negotiate
+
+import (
+	"testing"
+
+	"github.com/5tuartw/httpfromtcp/internal/headers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMediaType(t *testing.T) {
+	accept := func(value string) headers.Headers {
+		return headers.Headers{{Name: "Accept", Value: value}}
+	}
+
+	// Test: no Accept takes the first offer and still varies
+	resp := headers.Headers{}
+	got, err := MediaType(headers.Headers{}, &resp, "text/html", "application/json")
+	require.NoError(t, err)
+	assert.Equal(t, "text/html", got)
+	assert.Equal(t, "Accept", resp.Get("Vary"))
+
+	// Test: q-values decide
+	got, err = MediaType(accept("text/html;q=0.5, application/json"), nil, "text/html", "application/json")
+	require.NoError(t, err)
+	assert.Equal(t, "application/json", got)
+
+	// Test: the most specific range wins over a wildcard
+	got, err = MediaType(accept("*/*, text/html;q=0"), nil, "text/html", "application/json")
+	require.NoError(t, err)
+	assert.Equal(t, "application/json", got)
+	got, err = MediaType(accept("text/*;q=0.3, text/html;level=1;q=0.9, */*;q=0.1"), nil, "application/json", "text/plain", "text/html;level=1")
+	require.NoError(t, err)
+	assert.Equal(t, "text/html;level=1", got)
+
+	// Test: ties go to the earlier offer
+	got, err = MediaType(accept("text/*"), nil, "text/plain", "text/html")
+	require.NoError(t, err)
+	assert.Equal(t, "text/plain", got)
+
+	// Test: nothing acceptable
+	resp = headers.Headers{}
+	_, err = MediaType(accept("image/png"), &resp, "text/html", "application/json")
+	assert.ErrorIs(t, err, ErrNotAcceptable)
+	assert.Equal(t, "Accept", resp.Get("Vary"))
+
+	// Test: an invalid Accept is ignored
+	got, err = MediaType(accept("text/html;q=2"), nil, "application/json")
+	require.NoError(t, err)
+	assert.Equal(t, "application/json", got)
+}
+
+func TestLanguage(t *testing.T) {
+	req := headers.Headers{{Name: "Accept-Language", Value: "en;q=0.8, fr-CA, de;q=0"}}
+
+	// Test: prefix ranges match subtags
+	got, err := Language(req, nil, "de", "en-GB", "fr")
+	require.NoError(t, err)
+	assert.Equal(t, "en-GB", got)
+
+	// Test: a more specific tag in the offer
+	got, err = Language(req, nil, "en", "fr-ca")
+	require.NoError(t, err)
+	assert.Equal(t, "fr-ca", got)
+
+	_, err = Language(req, nil, "de", "es")
+	assert.ErrorIs(t, err, ErrNotAcceptable)
+}
+
+func TestCharset(t *testing.T) {
+	req := headers.Headers{{Name: "Accept-Charset", Value: "iso-8859-5, *;q=0.1"}}
+	resp := headers.Headers{{Name: "Vary", Value: "Accept"}}
+
+	got, err := Charset(req, &resp, "utf-8", "ISO-8859-5")
+	require.NoError(t, err)
+	assert.Equal(t, "ISO-8859-5", got)
+	assert.Equal(t, "Accept, Accept-Charset", resp.Get("Vary"))
+
+	got, err = Charset(req, nil, "utf-8")
+	require.NoError(t, err)
+	assert.Equal(t, "utf-8", got)
+}
@@ -0,0 +1,110 @@
+package proxyproto
+
+import (
+	"bufio"
+	"net"
+	"net/netip"
+	"sync"
+	"time"
+)
+
+// DefaultHeaderTimeout bounds how long a trusted peer may take to send the
+// header.
+const DefaultHeaderTimeout = 5 * time.Second
+
+// Listener expects a PROXY header on connections from trusted peers. Other
+// connections are passed through untouched, so they cannot claim another
+// client's address.
+type Listener struct {
+	net.Listener
+	Trusted       []netip.Prefix
+	HeaderTimeout time.Duration
+}
+
+func (l *Listener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	if !l.trusted(conn.RemoteAddr()) {
+		return conn, nil
+	}
+	timeout := l.HeaderTimeout
+	if timeout == 0 {
+		timeout = DefaultHeaderTimeout
+	}
+	return &Conn{Conn: conn, reader: bufio.NewReader(conn), timeout: timeout}, nil
+}
+
+func (l *Listener) trusted(addr net.Addr) bool {
+	addrPort, err := netip.ParseAddrPort(addr.String())
+	if err != nil {
+		return false
+	}
+	ip := addrPort.Addr().Unmap()
+	for _, prefix := range l.Trusted {
+		if prefix.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// Conn is a connection from a trusted peer. Its header is read on first use
+// and its addresses are those of the proxied client and destination.
+type Conn struct {
+	net.Conn
+	reader  *bufio.Reader
+	timeout time.Duration
+
+	once   sync.Once
+	header *Header
+	err    error
+}
+
+// Header reads the PROXY header if that has not happened yet. It sets and
+// then clears a read deadline, so call it before setting any of your own.
+func (c *Conn) Header() (*Header, error) {
+	c.once.Do(func() {
+		c.Conn.SetReadDeadline(time.Now().Add(c.timeout))
+		c.header, c.err = Read(c.reader)
+		c.Conn.SetReadDeadline(time.Time{})
+	})
+	return c.header, c.err
+}
+
+func (c *Conn) Read(p []byte) (int, error) {
+	_, err := c.Header()
+	if err != nil {
+		return 0, err
+	}
+	return c.reader.Read(p)
+}
+
+// RemoteAddr is the client address from the header, or the peer's address
+// for LOCAL headers and failed reads.
+func (c *Conn) RemoteAddr() net.Addr {
+	header, err := c.Header()
+	if err != nil || header.Source == nil {
+		return c.Conn.RemoteAddr()
+	}
+	return header.Source
+}
+
+// LocalAddr is the destination address from the header, or the
+// connection's own.
+func (c *Conn) LocalAddr() net.Addr {
+	header, err := c.Header()
+	if err != nil || header.Destination == nil {
+		return c.Conn.LocalAddr()
+	}
+	return header.Destination
+}
+
+// CloseWrite half-closes the underlying connection when it supports that.
+func (c *Conn) CloseWrite() error {
+	if halfCloser, ok := c.Conn.(interface{ CloseWrite() error }); ok {
+		return halfCloser.CloseWrite()
+	}
+	return nil
+}
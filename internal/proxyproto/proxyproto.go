@@ -0,0 +1,239 @@
+// Package proxyproto reads the HAProxy PROXY protocol header, versions 1 and
+// 2, that load balancers send ahead of the proxied connection's data.
+package proxyproto
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+)
+
+const maxV1Length = 107
+
+var v2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+var ErrNoHeader = errors.New("connection does not start with a PROXY protocol header")
+
+// Header is a parsed PROXY protocol header.
+type Header struct {
+	Version int
+	// Local is set for v2 LOCAL and v1 UNKNOWN headers, such as the
+	// balancer's own health checks. The addresses are then nil and the
+	// connection's own addresses apply.
+	Local       bool
+	Source      net.Addr
+	Destination net.Addr
+	// TLVs are the v2 type-length-value extensions, such as ALPN or the
+	// TLS details of a terminated connection.
+	TLVs []TLV
+}
+
+type TLV struct {
+	Type  byte
+	Value []byte
+}
+
+// Common TLV types from the specification.
+const (
+	TLVTypeALPN      byte = 0x01
+	TLVTypeAuthority byte = 0x02
+	TLVTypeCRC32C    byte = 0x03
+	TLVTypeNoop      byte = 0x04
+	TLVTypeUniqueID  byte = 0x05
+	TLVTypeSSL       byte = 0x20
+	TLVTypeNetNS     byte = 0x30
+)
+
+// TLV returns the value of the first TLV of type t.
+func (h *Header) TLV(t byte) ([]byte, bool) {
+	for _, tlv := range h.TLVs {
+		if tlv.Type == t {
+			return tlv.Value, true
+		}
+	}
+	return nil, false
+}
+
+// Read parses a v1 or v2 header from the start of r, leaving r at the first
+// byte of proxied data.
+func Read(r *bufio.Reader) (*Header, error) {
+	start, err := r.Peek(len(v2Signature))
+	if err != nil {
+		return nil, fmt.Errorf("error reading PROXY header: %w", err)
+	}
+	switch {
+	case bytes.Equal(start, v2Signature):
+		return readV2(r)
+	case bytes.HasPrefix(start, []byte("PROXY ")):
+		return readV1(r)
+	default:
+		return nil, ErrNoHeader
+	}
+}
+
+func readV1(r *bufio.Reader) (*Header, error) {
+	var line []byte
+	for {
+		c, err := r.ReadByte()
+		if err != nil {
+			return nil, fmt.Errorf("error reading PROXY header: %w", err)
+		}
+		line = append(line, c)
+		if c == '\n' {
+			break
+		}
+		if len(line) >= maxV1Length {
+			return nil, errors.New("PROXY v1 header too long")
+		}
+	}
+	if !bytes.HasSuffix(line, []byte("\r\n")) {
+		return nil, errors.New("PROXY v1 header not terminated by CRLF")
+	}
+
+	fields := strings.Split(string(line[:len(line)-2]), " ")
+	header := &Header{Version: 1}
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		header.Local = true
+		return header, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, fmt.Errorf("invalid PROXY v1 header: %q", line)
+	}
+
+	src, err := parseV1Addr(fields[1], fields[2], fields[4])
+	if err != nil {
+		return nil, err
+	}
+	dst, err := parseV1Addr(fields[1], fields[3], fields[5])
+	if err != nil {
+		return nil, err
+	}
+	header.Source = src
+	header.Destination = dst
+	return header, nil
+}
+
+func parseV1Addr(family, ip, port string) (*net.TCPAddr, error) {
+	addr := net.ParseIP(ip)
+	isV4 := addr != nil && addr.To4() != nil && !strings.Contains(ip, ":")
+	if addr == nil || isV4 != (family == "TCP4") {
+		return nil, fmt.Errorf("invalid %s address in PROXY v1 header: %q", family, ip)
+	}
+	// Ports are decimal without leading zeros
+	p, err := strconv.ParseUint(port, 10, 16)
+	if err != nil || (len(port) > 1 && port[0] == '0') {
+		return nil, fmt.Errorf("invalid port in PROXY v1 header: %q", port)
+	}
+	return &net.TCPAddr{IP: addr, Port: int(p)}, nil
+}
+
+func readV2(r *bufio.Reader) (*Header, error) {
+	fixed := make([]byte, 16)
+	_, err := io.ReadFull(r, fixed)
+	if err != nil {
+		return nil, fmt.Errorf("error reading PROXY header: %w", err)
+	}
+	if fixed[12]>>4 != 2 {
+		return nil, fmt.Errorf("unsupported PROXY version: %d", fixed[12]>>4)
+	}
+	command := fixed[12] & 0x0f
+	family := fixed[13]
+	length := int(binary.BigEndian.Uint16(fixed[14:16]))
+
+	payload := make([]byte, length)
+	_, err = io.ReadFull(r, payload)
+	if err != nil {
+		return nil, fmt.Errorf("error reading PROXY header: %w", err)
+	}
+
+	header := &Header{Version: 2}
+	switch command {
+	case 0x0:
+		header.Local = true
+	case 0x1:
+	default:
+		return nil, fmt.Errorf("unsupported PROXY v2 command: %d", command)
+	}
+
+	addrLength := 0
+	switch family {
+	case 0x11, 0x12: // TCP or UDP over IPv4
+		addrLength = 12
+	case 0x21, 0x22: // TCP or UDP over IPv6
+		addrLength = 36
+	case 0x31, 0x32: // Unix stream or datagram
+		addrLength = 216
+	case 0x00:
+		if !header.Local {
+			return nil, errors.New("PROXY v2 command PROXY with unspecified address family")
+		}
+	default:
+		return nil, fmt.Errorf("unsupported PROXY v2 address family: %#x", family)
+	}
+	if len(payload) < addrLength {
+		return nil, errors.New("PROXY v2 header too short for its addresses")
+	}
+
+	if !header.Local {
+		header.Source, header.Destination = parseV2Addrs(family, payload[:addrLength])
+	}
+	header.TLVs, err = parseTLVs(payload[addrLength:])
+	if err != nil {
+		return nil, err
+	}
+	return header, nil
+}
+
+func parseV2Addrs(family byte, data []byte) (net.Addr, net.Addr) {
+	switch family >> 4 {
+	case 0x1, 0x2:
+		ipLength := 4
+		if family>>4 == 0x2 {
+			ipLength = 16
+		}
+		src := net.IP(bytes.Clone(data[:ipLength]))
+		dst := net.IP(bytes.Clone(data[ipLength : 2*ipLength]))
+		srcPort := int(binary.BigEndian.Uint16(data[2*ipLength:]))
+		dstPort := int(binary.BigEndian.Uint16(data[2*ipLength+2:]))
+		if family&0x0f == 0x2 {
+			return &net.UDPAddr{IP: src, Port: srcPort}, &net.UDPAddr{IP: dst, Port: dstPort}
+		}
+		return &net.TCPAddr{IP: src, Port: srcPort}, &net.TCPAddr{IP: dst, Port: dstPort}
+	default:
+		network := "unix"
+		if family&0x0f == 0x2 {
+			network = "unixgram"
+		}
+		return &net.UnixAddr{Name: cString(data[:108]), Net: network},
+			&net.UnixAddr{Name: cString(data[108:216]), Net: network}
+	}
+}
+
+func parseTLVs(data []byte) ([]TLV, error) {
+	var tlvs []TLV
+	for len(data) > 0 {
+		if len(data) < 3 {
+			return nil, errors.New("truncated PROXY v2 TLV")
+		}
+		length := int(binary.BigEndian.Uint16(data[1:3]))
+		if len(data) < 3+length {
+			return nil, errors.New("truncated PROXY v2 TLV")
+		}
+		tlvs = append(tlvs, TLV{Type: data[0], Value: bytes.Clone(data[3 : 3+length])})
+		data = data[3+length:]
+	}
+	return tlvs, nil
+}
+
+func cString(b []byte) string {
+	if i := bytes.IndexByte(b, 0); i != -1 {
+		b = b[:i]
+	}
+	return string(b)
+}
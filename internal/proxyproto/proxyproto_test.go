@@ -0,0 +1,116 @@
+package proxyproto
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/netip"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func read(data []byte) (*Header, string, error) {
+	r := bufio.NewReader(bytes.NewReader(data))
+	header, err := Read(r)
+	rest, _ := io.ReadAll(r)
+	return header, string(rest), err
+}
+
+func v2(command, family byte, payload []byte) []byte {
+	data := append([]byte{}, v2Signature...)
+	data = append(data, 0x20|command, family)
+	data = binary.BigEndian.AppendUint16(data, uint16(len(payload)))
+	return append(data, payload...)
+}
+
+func TestReadV1(t *testing.T) {
+	// Test: TCP4 header followed by the proxied data
+	header, rest, err := read([]byte("PROXY TCP4 192.0.2.10 198.51.100.1 56324 443\r\nGET / HTTP/1.1\r\n"))
+	require.NoError(t, err)
+	assert.Equal(t, 1, header.Version)
+	assert.Equal(t, "192.0.2.10:56324", header.Source.String())
+	assert.Equal(t, "198.51.100.1:443", header.Destination.String())
+	assert.Equal(t, "GET / HTTP/1.1\r\n", rest)
+
+	// Test: TCP6 and UNKNOWN
+	header, _, err = read([]byte("PROXY TCP6 2001:db8::1 2001:db8::2 1000 80\r\n"))
+	require.NoError(t, err)
+	assert.Equal(t, "[2001:db8::1]:1000", header.Source.String())
+	header, _, err = read([]byte("PROXY UNKNOWN ffff::1 ffff::2 1 2\r\n"))
+	require.NoError(t, err)
+	assert.True(t, header.Local)
+	assert.Nil(t, header.Source)
+
+	// Test: malformed headers
+	for _, bad := range []string{
+		"PROXY TCP4 192.0.2.10 198.51.100.1 56324\r\n",
+		"PROXY TCP4 2001:db8::1 198.51.100.1 1 2\r\n",
+		"PROXY TCP4 192.0.2.10 198.51.100.1 056324 443\r\n",
+		"PROXY TCP4 192.0.2.10 198.51.100.1 70000 443\r\n",
+		"PROXY TCP4 192.0.2.10 198.51.100.1 1 443\n",
+		"PROXY TCP4 " + strings.Repeat("1", 120) + "\r\n",
+	} {
+		_, _, err = read([]byte(bad))
+		assert.Error(t, err, bad)
+	}
+
+	// Test: no header at all
+	_, _, err = read([]byte("GET / HTTP/1.1\r\nHost: x\r\n\r\n"))
+	assert.ErrorIs(t, err, ErrNoHeader)
+}
+
+func TestReadV2(t *testing.T) {
+	// Test: TCP over IPv4 with ALPN and authority TLVs
+	payload := []byte{192, 0, 2, 10, 198, 51, 100, 1, 0xdc, 0x04, 0x01, 0xbb}
+	payload = append(payload, TLVTypeALPN, 0, 2, 'h', '2')
+	payload = append(payload, TLVTypeAuthority, 0, 11)
+	payload = append(payload, "example.com"...)
+	header, rest, err := read(append(v2(0x1, 0x11, payload), "data"...))
+	require.NoError(t, err)
+	assert.Equal(t, 2, header.Version)
+	assert.False(t, header.Local)
+	assert.Equal(t, "192.0.2.10:56324", header.Source.String())
+	assert.Equal(t, "198.51.100.1:443", header.Destination.String())
+	alpn, ok := header.TLV(TLVTypeALPN)
+	assert.True(t, ok)
+	assert.Equal(t, "h2", string(alpn))
+	authority, _ := header.TLV(TLVTypeAuthority)
+	assert.Equal(t, "example.com", string(authority))
+	assert.Equal(t, "data", rest)
+
+	// Test: TCP over IPv6
+	payload = append(append(net.ParseIP("2001:db8::1").To16(), net.ParseIP("2001:db8::2").To16()...), 0, 80, 1, 0)
+	header, _, err = read(v2(0x1, 0x21, payload))
+	require.NoError(t, err)
+	assert.Equal(t, "[2001:db8::1]:80", header.Source.String())
+	assert.Equal(t, "[2001:db8::2]:256", header.Destination.String())
+
+	// Test: LOCAL health check
+	header, rest, err = read(append(v2(0x0, 0x00, nil), "x"...))
+	require.NoError(t, err)
+	assert.True(t, header.Local)
+	assert.Nil(t, header.Source)
+	assert.Equal(t, "x", rest)
+
+	// Test: malformed headers
+	_, _, err = read(v2(0x1, 0x11, []byte{1, 2, 3}))
+	assert.Error(t, err)
+	_, _, err = read(v2(0x1, 0x00, nil))
+	assert.Error(t, err)
+	_, _, err = read(v2(0x2, 0x11, make([]byte, 12)))
+	assert.Error(t, err)
+	_, _, err = read(v2(0x1, 0x11, append(make([]byte, 12), TLVTypeNoop, 0, 5)))
+	assert.Error(t, err)
+}
+
+func TestListener(t *testing.T) {
+	l := &Listener{Trusted: []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}}
+	assert.True(t, l.trusted(&net.TCPAddr{IP: net.ParseIP("10.1.2.3"), Port: 1}))
+	assert.True(t, l.trusted(&net.TCPAddr{IP: net.ParseIP("::ffff:10.1.2.3"), Port: 1}))
+	assert.False(t, l.trusted(&net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 1}))
+}
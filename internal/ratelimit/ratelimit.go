@@ -0,0 +1,177 @@
+// Package ratelimit throttles clients with one token bucket per key, such as
+// the client IP or an API key.
+package ratelimit
+
+import (
+	"math"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/5tuartw/httpfromtcp/internal/request"
+	"github.com/5tuartw/httpfromtcp/internal/response"
+	"github.com/5tuartw/httpfromtcp/internal/server"
+)
+
+// KeyFunc picks the bucket for a request. Requests it returns "" for share
+// one bucket.
+type KeyFunc func(req *request.Request) string
+
+// ByRemoteIP keys on the client IP address, ignoring the port.
+func ByRemoteIP(req *request.Request) string {
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return req.RemoteAddr
+	}
+	return host
+}
+
+// ByClientIP keys on the effective client IP, which a forwarded-header
+// resolver earlier in the chain may have taken from trusted proxies.
+func ByClientIP(req *request.Request) string {
+	return req.ClientAddr
+}
+
+// ByHeader keys on the value of a request field such as an API key.
+func ByHeader(name string) KeyFunc {
+	return func(req *request.Request) string {
+		return req.Headers.Get(name)
+	}
+}
+
+type Options struct {
+	// Rate is how many requests per second a key may make on average. It
+	// must be positive.
+	Rate float64
+	// Burst is how many requests a key may make at once; at least 1.
+	Burst int
+	// Key defaults to ByRemoteIP.
+	Key KeyFunc
+	// IdleTimeout is how long an unused bucket is kept. It defaults to the
+	// time an empty bucket takes to fill, after which a new bucket would
+	// behave the same.
+	IdleTimeout time.Duration
+	// Clock defaults to the system clock.
+	Clock Clock
+}
+
+// Result is the outcome of taking a token.
+type Result struct {
+	Allowed   bool
+	Limit     int
+	Remaining int
+	// RetryAfter is how long until a token is available, when not allowed.
+	RetryAfter time.Duration
+	// Reset is how long until the bucket is full again.
+	Reset time.Duration
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+type Limiter struct {
+	opts Options
+
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+func New(opts Options) *Limiter {
+	if opts.Rate <= 0 {
+		panic("ratelimit: Rate must be positive")
+	}
+	if opts.Burst < 1 {
+		opts.Burst = 1
+	}
+	if opts.Key == nil {
+		opts.Key = ByRemoteIP
+	}
+	if opts.Clock == nil {
+		opts.Clock = systemClock{}
+	}
+	if opts.IdleTimeout <= 0 {
+		opts.IdleTimeout = seconds(float64(opts.Burst) / opts.Rate)
+	}
+	return &Limiter{
+		opts:      opts,
+		buckets:   map[string]*bucket{},
+		lastSweep: opts.Clock.Now(),
+	}
+}
+
+// Allow takes a token from the bucket for key if one is available.
+func (l *Limiter) Allow(key string) Result {
+	now := l.opts.Clock.Now()
+	burst := float64(l.opts.Burst)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.sweep(now)
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: burst, last: now}
+		l.buckets[key] = b
+	}
+	b.tokens = math.Min(burst, b.tokens+now.Sub(b.last).Seconds()*l.opts.Rate)
+	b.last = now
+
+	result := Result{Limit: l.opts.Burst}
+	if b.tokens >= 1 {
+		b.tokens--
+		result.Allowed = true
+	} else {
+		result.RetryAfter = seconds((1 - b.tokens) / l.opts.Rate)
+	}
+	result.Remaining = int(b.tokens)
+	result.Reset = seconds((burst - b.tokens) / l.opts.Rate)
+	return result
+}
+
+// sweep drops idle buckets, at most once per IdleTimeout.
+func (l *Limiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < l.opts.IdleTimeout {
+		return
+	}
+	for key, b := range l.buckets {
+		if now.Sub(b.last) >= l.opts.IdleTimeout {
+			delete(l.buckets, key)
+		}
+	}
+	l.lastSweep = now
+}
+
+// Handler wraps next so that requests over the limit are answered 429 Too
+// Many Requests with Retry-After. Every response carries the RateLimit-*
+// fields describing the client's remaining quota.
+func (l *Limiter) Handler(next server.Handler) server.Handler {
+	return func(w *response.Writer, req *request.Request) {
+		result := l.Allow(l.opts.Key(req))
+
+		h := w.Header()
+		h.Set("RateLimit-Limit", strconv.Itoa(result.Limit))
+		h.Set("RateLimit-Remaining", strconv.Itoa(result.Remaining))
+		h.Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(result.Reset)))
+		if result.Allowed {
+			next(w, req)
+			return
+		}
+
+		h.Set("Retry-After", strconv.Itoa(ceilSeconds(result.RetryAfter)))
+		w.WriteError(req, response.TooManyRequests, response.Problem{
+			Detail: "Rate limit exceeded. Try again in " + strconv.Itoa(ceilSeconds(result.RetryAfter)) + " seconds.",
+		})
+	}
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
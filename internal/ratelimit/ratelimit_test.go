@@ -0,0 +1,94 @@
+package ratelimit
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/5tuartw/httpfromtcp/internal/request"
+	"github.com/5tuartw/httpfromtcp/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAllow(t *testing.T) {
+	clock := NewFakeClock(time.Unix(0, 0))
+	l := New(Options{Rate: 2, Burst: 3, Clock: clock})
+
+	// Test: the burst is available at once
+	for i := 2; i >= 0; i-- {
+		result := l.Allow("a")
+		assert.True(t, result.Allowed)
+		assert.Equal(t, i, result.Remaining)
+	}
+	result := l.Allow("a")
+	assert.False(t, result.Allowed)
+	assert.Equal(t, 500*time.Millisecond, result.RetryAfter)
+	assert.Equal(t, 1500*time.Millisecond, result.Reset)
+
+	// Test: keys have separate buckets
+	assert.True(t, l.Allow("b").Allowed)
+
+	// Test: tokens refill at the rate, up to the burst
+	clock.Advance(500 * time.Millisecond)
+	assert.True(t, l.Allow("a").Allowed)
+	assert.False(t, l.Allow("a").Allowed)
+	clock.Advance(time.Hour)
+	assert.Equal(t, 2, l.Allow("a").Remaining)
+}
+
+func TestEviction(t *testing.T) {
+	clock := NewFakeClock(time.Unix(0, 0))
+	l := New(Options{Rate: 1, Burst: 10, Clock: clock})
+	l.Allow("a")
+	clock.Advance(5 * time.Second)
+	l.Allow("b")
+	assert.Len(t, l.buckets, 2)
+
+	// Test: buckets idle for the refill time are dropped, active ones kept
+	clock.Advance(6 * time.Second)
+	l.Allow("c")
+	assert.Len(t, l.buckets, 2)
+	assert.NotContains(t, l.buckets, "a")
+}
+
+func TestHandler(t *testing.T) {
+	clock := NewFakeClock(time.Unix(0, 0))
+	l := New(Options{Rate: 0.1, Burst: 1, Key: ByHeader("X-Api-Key"), Clock: clock})
+	handler := l.Handler(func(w *response.Writer, req *request.Request) {
+		w.WriteStatusLine(response.OK)
+		w.WriteHeaders(response.GetDefaultHeaders(0))
+	})
+	serve := func(key string) string {
+		req, err := request.RequestFromReader(strings.NewReader("GET / HTTP/1.1\r\nHost: localhost\r\nX-Api-Key: " + key + "\r\n\r\n"))
+		require.NoError(t, err)
+		req.RemoteAddr = "192.0.2.1:1234"
+		buf := &bytes.Buffer{}
+		handler(&response.Writer{IoWriter: buf}, req)
+		return buf.String()
+	}
+
+	// Test: allowed responses carry the quota
+	out := serve("k1")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 200 OK\r\n"), out)
+	assert.Contains(t, out, "RateLimit-Limit: 1\r\n")
+	assert.Contains(t, out, "RateLimit-Remaining: 0\r\n")
+	assert.Contains(t, out, "RateLimit-Reset: 10\r\n")
+
+	// Test: over the limit is 429 with Retry-After
+	clock.Advance(2500 * time.Millisecond)
+	out = serve("k1")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 429 Too Many Requests\r\n"), out)
+	assert.Contains(t, out, "Retry-After: 8\r\n")
+
+	// Test: another key is unaffected
+	out = serve("k2")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 200 OK\r\n"), out)
+}
+
+func TestByRemoteIP(t *testing.T) {
+	assert.Equal(t, "2001:db8::1", ByRemoteIP(&request.Request{RemoteAddr: "[2001:db8::1]:443"}))
+	assert.Equal(t, "pipe", ByRemoteIP(&request.Request{RemoteAddr: "pipe"}))
+	assert.Equal(t, "192.0.2.60", ByClientIP(&request.Request{RemoteAddr: "10.0.0.1:80", ClientAddr: "192.0.2.60"}))
+}
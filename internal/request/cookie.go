@@ -0,0 +1,24 @@
+package request
+
+import (
+	"github.com/5tuartw/httpfromtcp/internal/cookie"
+)
+
+// Cookies returns the name/value pairs from every Cookie header line.
+func (r *Request) Cookies() []cookie.Cookie {
+	var cookies []cookie.Cookie
+	for _, value := range r.Headers.Values("Cookie") {
+		cookies = append(cookies, cookie.Parse(value)...)
+	}
+	return cookies
+}
+
+// Cookie returns the first cookie called name.
+func (r *Request) Cookie(name string) (cookie.Cookie, bool) {
+	for _, c := range r.Cookies() {
+		if c.Name == name {
+			return c, true
+		}
+	}
+	return cookie.Cookie{}, false
+}
@@ -0,0 +1,257 @@
+package request
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/5tuartw/httpfromtcp/internal/headers"
+)
+
+var (
+	ErrNotForm      = errors.New("request body is not a form")
+	ErrTooManyParts = errors.New("multipart form has too many parts")
+	ErrPartTooLarge = errors.New("multipart form part is too large")
+	ErrFormTooLarge = errors.New("form is too large")
+)
+
+// FormOptions limits what ParseForm and ParseMultipartForm will accept.
+// Zero fields take their value from DefaultFormOptions.
+type FormOptions struct {
+	// MaxBytes is the largest URL-encoded body ParseForm reads.
+	MaxBytes int64
+	// MaxMemory is how many bytes of multipart values and file content are
+	// kept in memory in total. Values beyond it are refused; file parts are
+	// written to temporary files instead.
+	MaxMemory int64
+	// MaxParts is the largest number of parts accepted.
+	MaxParts int
+	// MaxPartSize is the largest size of a single part, file or not.
+	MaxPartSize int64
+}
+
+var DefaultFormOptions = FormOptions{
+	MaxBytes:    10 << 20,
+	MaxMemory:   10 << 20,
+	MaxParts:    1000,
+	MaxPartSize: 32 << 20,
+}
+
+// Form is a parsed multipart/form-data body.
+type Form struct {
+	Values url.Values
+	Files  map[string][]*FileHeader
+}
+
+// FileHeader describes a file part. Its content is in memory or, past the
+// memory threshold, in a temporary file removed by Form.RemoveAll.
+type FileHeader struct {
+	Filename string
+	Headers  headers.Headers
+	Size     int64
+
+	content  []byte
+	tempFile string
+}
+
+// Open returns the content of the file part.
+func (f *FileHeader) Open() (io.ReadCloser, error) {
+	if f.tempFile != "" {
+		return os.Open(f.tempFile)
+	}
+	return io.NopCloser(bytes.NewReader(f.content)), nil
+}
+
+// RemoveAll deletes any temporary files holding file parts.
+func (f *Form) RemoveAll() error {
+	var errs []error
+	for _, files := range f.Files {
+		for _, file := range files {
+			if file.tempFile != "" {
+				errs = append(errs, os.Remove(file.tempFile))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Query returns the parameters in the query string of the request target.
+func (r *Request) Query() url.Values {
+	_, rawQuery, _ := strings.Cut(r.RequestLine.RequestTarget, "?")
+	values, _ := url.ParseQuery(rawQuery)
+	return values
+}
+
+// ParseForm decodes an application/x-www-form-urlencoded body of at most
+// opts.MaxBytes.
+func (r *Request) ParseForm(opts FormOptions) (url.Values, error) {
+	mediaType, _, err := r.Headers.ContentType()
+	if err != nil {
+		return nil, err
+	}
+	if mediaType != "application/x-www-form-urlencoded" {
+		return nil, fmt.Errorf("%w: Content-Type is %q", ErrNotForm, mediaType)
+	}
+
+	if opts.MaxBytes == 0 {
+		opts.MaxBytes = DefaultFormOptions.MaxBytes
+	}
+	tooLarge := fmt.Errorf("%w: body is over %d bytes", ErrFormTooLarge, opts.MaxBytes)
+	if r.contentLength > opts.MaxBytes {
+		return nil, tooLarge
+	}
+	body, err := io.ReadAll(io.LimitReader(r.BodyReader(), opts.MaxBytes+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(body)) > opts.MaxBytes {
+		return nil, tooLarge
+	}
+	values, err := url.ParseQuery(string(body))
+	if err != nil {
+		return nil, fmt.Errorf("invalid form body: %v", err)
+	}
+	return values, nil
+}
+
+// ParseMultipartForm decodes a multipart/form-data body, reading it part by
+// part from the connection when it has not been read yet.
+func (r *Request) ParseMultipartForm(opts FormOptions) (*Form, error) {
+	mediaType, params, err := r.Headers.ContentType()
+	if err != nil {
+		return nil, err
+	}
+	if mediaType != "multipart/form-data" || params["boundary"] == "" {
+		return nil, fmt.Errorf("%w: Content-Type is %q", ErrNotForm, mediaType)
+	}
+
+	if opts.MaxMemory == 0 {
+		opts.MaxMemory = DefaultFormOptions.MaxMemory
+	}
+	if opts.MaxParts == 0 {
+		opts.MaxParts = DefaultFormOptions.MaxParts
+	}
+	if opts.MaxPartSize == 0 {
+		opts.MaxPartSize = DefaultFormOptions.MaxPartSize
+	}
+
+	form := &Form{
+		Values: url.Values{},
+		Files:  map[string][]*FileHeader{},
+	}
+	body := r.BodyReader()
+	err = form.read(multipart.NewReader(body, params["boundary"]), opts)
+	if err != nil {
+		form.RemoveAll()
+		return nil, err
+	}
+
+	// Finish the body so the connection is ready for the next request
+	_, err = io.Copy(io.Discard, body)
+	if err != nil {
+		form.RemoveAll()
+		return nil, err
+	}
+	return form, nil
+}
+
+func (f *Form) read(reader *multipart.Reader, opts FormOptions) error {
+	memoryLeft := opts.MaxMemory
+	parts := 0
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("invalid multipart body: %v", err)
+		}
+
+		parts++
+		if parts > opts.MaxParts {
+			return fmt.Errorf("%w: limit is %d", ErrTooManyParts, opts.MaxParts)
+		}
+
+		err = f.readPart(part, opts.MaxPartSize, &memoryLeft)
+		part.Close()
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (f *Form) readPart(part *multipart.Part, maxSize int64, memoryLeft *int64) error {
+	name := part.FormName()
+	limited := io.LimitReader(part, maxSize+1)
+
+	if part.FileName() == "" {
+		value, err := io.ReadAll(io.LimitReader(limited, max(*memoryLeft, 0)+1))
+		if err != nil {
+			return fmt.Errorf("error reading part %q: %v", name, err)
+		}
+		size := int64(len(value))
+		if size > maxSize {
+			return fmt.Errorf("%w: %q is over %d bytes", ErrPartTooLarge, name, maxSize)
+		}
+		// Values have nowhere to go but memory
+		if size > *memoryLeft {
+			return fmt.Errorf("%w: value %q is over the memory limit", ErrFormTooLarge, name)
+		}
+		*memoryLeft -= size
+		f.Values.Add(name, string(value))
+		return nil
+	}
+
+	file := &FileHeader{
+		Filename: part.FileName(),
+		Headers:  headers.HttpCopy(http.Header(part.Header)),
+	}
+
+	// Keep the file in memory while it fits, otherwise spill to disk
+	var buf bytes.Buffer
+	n, err := io.CopyN(&buf, limited, max(*memoryLeft, 0)+1)
+	if err != nil && err != io.EOF {
+		return fmt.Errorf("error reading part %q: %v", name, err)
+	}
+	if n <= *memoryLeft {
+		*memoryLeft -= n
+		file.content = buf.Bytes()
+		file.Size = n
+	} else {
+		size, err := spill(&buf, limited, file)
+		if err != nil {
+			return fmt.Errorf("error storing part %q: %v", name, err)
+		}
+		file.Size = size
+	}
+	// Recorded before the size check so RemoveAll cleans up the temp file
+	f.Files[name] = append(f.Files[name], file)
+
+	if file.Size > maxSize {
+		return fmt.Errorf("%w: %q is over %d bytes", ErrPartTooLarge, name, maxSize)
+	}
+	return nil
+}
+
+func spill(buffered io.Reader, rest io.Reader, file *FileHeader) (int64, error) {
+	tempFile, err := os.CreateTemp("", "multipart-")
+	if err != nil {
+		return 0, err
+	}
+	defer tempFile.Close()
+
+	n, err := io.Copy(tempFile, io.MultiReader(buffered, rest))
+	if err != nil {
+		tempFile.Close()
+		os.Remove(tempFile.Name())
+		return 0, err
+	}
+	file.tempFile = tempFile.Name()
+	return n, nil
+}
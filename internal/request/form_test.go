@@ -0,0 +1,161 @@
+package request
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func multipartRequest(body string, expectContinue bool) string {
+	expect := ""
+	if expectContinue {
+		expect = "Expect: 100-continue\r\n"
+	}
+	return "POST /upload HTTP/1.1\r\n" +
+		"Host: localhost:42069\r\n" +
+		"Content-Type: multipart/form-data; boundary=XYZ\r\n" +
+		expect +
+		fmt.Sprintf("Content-Length: %d\r\n", len(body)) +
+		"\r\n" + body
+}
+
+const multipartBody = "--XYZ\r\n" +
+	"Content-Disposition: form-data; name=\"title\"\r\n" +
+	"\r\n" +
+	"My upload\r\n" +
+	"--XYZ\r\n" +
+	"Content-Disposition: form-data; name=\"file\"; filename=\"notes.txt\"\r\n" +
+	"Content-Type: text/plain\r\n" +
+	"\r\n" +
+	"0123456789abcdef\r\n" +
+	"--XYZ--\r\n"
+
+func TestParseForm(t *testing.T) {
+	// Test: URL-encoded body
+	r, err := RequestFromReader(strings.NewReader("POST /login?next=%2Fhome HTTP/1.1\r\n" +
+		"Host: localhost:42069\r\n" +
+		"Content-Type: application/x-www-form-urlencoded\r\n" +
+		"Content-Length: 28\r\n" +
+		"\r\n" +
+		"user=lane&tags=a&tags=b+c%21"))
+	require.NoError(t, err)
+	values, err := r.ParseForm(FormOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, "lane", values.Get("user"))
+	assert.Equal(t, []string{"a", "b c!"}, values["tags"])
+	assert.Equal(t, "/home", r.Query().Get("next"))
+
+	// Test: Wrong content type
+	r, err = RequestFromReader(strings.NewReader("POST / HTTP/1.1\r\nHost: localhost:42069\r\nContent-Type: application/json\r\nContent-Length: 2\r\n\r\n{}"))
+	require.NoError(t, err)
+	_, err = r.ParseForm(FormOptions{})
+	require.ErrorIs(t, err, ErrNotForm)
+
+	// Test: Size limit, declared up front or found while reading
+	r, err = NewReader(strings.NewReader("POST / HTTP/1.1\r\nHost: localhost:42069\r\nContent-Type: application/x-www-form-urlencoded\r\nContent-Length: 20971520\r\n\r\na=")).Next()
+	require.NoError(t, err)
+	_, err = r.ParseForm(FormOptions{})
+	require.ErrorIs(t, err, ErrFormTooLarge)
+	assert.True(t, r.BodyPending())
+
+	r, err = RequestFromReader(strings.NewReader("POST / HTTP/1.1\r\nHost: localhost:42069\r\nContent-Type: application/x-www-form-urlencoded\r\nTransfer-Encoding: chunked\r\n\r\n8\r\na=123456\r\n0\r\n\r\n"))
+	require.NoError(t, err)
+	_, err = r.ParseForm(FormOptions{MaxBytes: 4})
+	require.ErrorIs(t, err, ErrFormTooLarge)
+	values, err = r.ParseForm(FormOptions{MaxBytes: 8})
+	require.NoError(t, err)
+	assert.Equal(t, "123456", values.Get("a"))
+}
+
+func TestParseMultipartForm(t *testing.T) {
+	// Test: Values and in-memory file, streamed from a deferred body
+	r, err := RequestFromReader(&chunkReader{data: multipartRequest(multipartBody, true), numBytesPerRead: 7})
+	require.NoError(t, err)
+	require.True(t, r.BodyPending())
+	form, err := r.ParseMultipartForm(FormOptions{})
+	require.NoError(t, err)
+	defer form.RemoveAll()
+	assert.False(t, r.BodyPending())
+	assert.Equal(t, "My upload", form.Values.Get("title"))
+	require.Len(t, form.Files["file"], 1)
+	file := form.Files["file"][0]
+	assert.Equal(t, "notes.txt", file.Filename)
+	assert.Equal(t, "text/plain", file.Headers.Get("Content-Type"))
+	assert.Equal(t, int64(16), file.Size)
+	f, err := file.Open()
+	require.NoError(t, err)
+	content, err := io.ReadAll(f)
+	require.NoError(t, err)
+	assert.Equal(t, "0123456789abcdef", string(content))
+	assert.Empty(t, file.tempFile)
+
+	// Test: A plain form post read with a Reader is streamed too
+	r, err = NewReader(&chunkReader{data: multipartRequest(multipartBody, false), numBytesPerRead: 7}).Next()
+	require.NoError(t, err)
+	require.True(t, r.BodyPending())
+	form, err = r.ParseMultipartForm(FormOptions{})
+	require.NoError(t, err)
+	assert.False(t, r.BodyPending())
+	assert.Empty(t, r.Body)
+	assert.Equal(t, "My upload", form.Values.Get("title"))
+
+	// Test: File over the memory threshold spills to disk
+	r, err = RequestFromReader(strings.NewReader(multipartRequest(multipartBody, false)))
+	require.NoError(t, err)
+	form, err = r.ParseMultipartForm(FormOptions{MaxMemory: 12})
+	require.NoError(t, err)
+	file = form.Files["file"][0]
+	require.NotEmpty(t, file.tempFile)
+	f, err = file.Open()
+	require.NoError(t, err)
+	content, err = io.ReadAll(f)
+	f.Close()
+	require.NoError(t, err)
+	assert.Equal(t, "0123456789abcdef", string(content))
+	require.NoError(t, form.RemoveAll())
+	_, err = os.Stat(file.tempFile)
+	assert.True(t, os.IsNotExist(err))
+
+	// Test: A file cut off mid-upload leaves no temp file behind
+	tempDir := t.TempDir()
+	t.Setenv("TMPDIR", tempDir)
+	truncated := multipartRequest(multipartBody, true)
+	r, err = RequestFromReader(strings.NewReader(truncated[:len(truncated)-20]))
+	require.NoError(t, err)
+	_, err = r.ParseMultipartForm(FormOptions{MaxMemory: 12})
+	require.Error(t, err)
+	entries, err := os.ReadDir(tempDir)
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+
+	// Test: Limits
+	r, err = RequestFromReader(strings.NewReader(multipartRequest(multipartBody, false)))
+	require.NoError(t, err)
+	_, err = r.ParseMultipartForm(FormOptions{MaxParts: 1})
+	require.ErrorIs(t, err, ErrTooManyParts)
+
+	r, err = RequestFromReader(strings.NewReader(multipartRequest(multipartBody, false)))
+	require.NoError(t, err)
+	_, err = r.ParseMultipartForm(FormOptions{MaxPartSize: 10})
+	require.ErrorIs(t, err, ErrPartTooLarge)
+
+	r, err = RequestFromReader(strings.NewReader(multipartRequest(multipartBody, false)))
+	require.NoError(t, err)
+	_, err = r.ParseMultipartForm(FormOptions{MaxPartSize: 10, MaxMemory: 12})
+	require.ErrorIs(t, err, ErrPartTooLarge)
+
+	// Test: Values count against the memory limit, since they cannot spill
+	values := ""
+	for i := 0; i < 4; i++ {
+		values += "--XYZ\r\nContent-Disposition: form-data; name=\"v\"\r\n\r\n" + strings.Repeat("x", 400) + "\r\n"
+	}
+	r, err = RequestFromReader(strings.NewReader(multipartRequest(values+"--XYZ--\r\n", false)))
+	require.NoError(t, err)
+	_, err = r.ParseMultipartForm(FormOptions{MaxMemory: 1024})
+	require.ErrorIs(t, err, ErrFormTooLarge)
+}
@@ -0,0 +1,160 @@
+package request
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+type chunkState int
+
+const (
+	chunkStateSize chunkState = iota
+	chunkStateData
+	chunkStateDataEnd
+	chunkStateTrailers
+)
+
+const (
+	maxChunkSizeDigits = 16
+	// Without a cap an endless chunk extension or trailer section would
+	// grow the read buffer until memory runs out.
+	maxChunkLineBytes = 4 << 10
+	maxTrailerBytes   = 8 << 10
+)
+
+// setFraming decides how the body is delimited once the headers are parsed,
+// following RFC 9112 section 6 strictly so that no proxy in front of us can
+// read the message boundaries differently.
+func (r *Request) setFraming() error {
+	r.contentLength = -1
+
+	hasTransferEncoding := r.Headers.Has("Transfer-Encoding")
+	hasContentLength := r.Headers.Has("Content-Length")
+
+	if hasTransferEncoding {
+		if hasContentLength {
+			return errors.New("request has both Transfer-Encoding and Content-Length")
+		}
+		if r.RequestLine.HttpVersion == "1.0" {
+			return errors.New("Transfer-Encoding is not allowed in an HTTP/1.0 request")
+		}
+		codings := strings.Split(r.Headers.Get("Transfer-Encoding"), ",")
+		for i, coding := range codings {
+			coding = strings.ToLower(strings.Trim(coding, " \t"))
+			if coding == "chunked" && i != len(codings)-1 {
+				return errors.New("chunked must be the final transfer coding")
+			}
+			if coding != "chunked" {
+				return fmt.Errorf("%w: %q", ErrUnsupportedTransferCoding, coding)
+			}
+		}
+		r.chunked = true
+		return nil
+	}
+
+	if hasContentLength {
+		length, err := r.Headers.ContentLength()
+		if err != nil {
+			return err
+		}
+		r.contentLength = length
+	}
+	return nil
+}
+
+func (r *Request) parseChunked(data []byte) (int, error) {
+	switch r.chunkState {
+	case chunkStateSize:
+		idx := bytes.Index(data, []byte(crlf))
+		if idx > maxChunkLineBytes || (idx == -1 && len(data) > maxChunkLineBytes) {
+			return 0, fmt.Errorf("chunk size line longer than %d bytes", maxChunkLineBytes)
+		}
+		if idx == -1 {
+			if bytes.IndexByte(data, '\n') != -1 {
+				return 0, errors.New("bare LF in chunk size line")
+			}
+			return 0, nil
+		}
+		size, err := parseChunkSize(data[:idx])
+		if err != nil {
+			return 0, err
+		}
+		if size == 0 {
+			r.chunkState = chunkStateTrailers
+		} else {
+			r.chunkState = chunkStateData
+			r.chunkRemaining = size
+		}
+		return idx + len(crlf), nil
+	case chunkStateData:
+		n := int64(len(data))
+		if n > r.chunkRemaining {
+			n = r.chunkRemaining
+		}
+		r.Body = append(r.Body, data[:n]...)
+		r.chunkRemaining -= n
+		if r.chunkRemaining == 0 {
+			r.chunkState = chunkStateDataEnd
+		}
+		return int(n), nil
+	case chunkStateDataEnd:
+		if len(data) < len(crlf) {
+			return 0, nil
+		}
+		if !bytes.HasPrefix(data, []byte(crlf)) {
+			return 0, errors.New("chunk data not followed by CRLF")
+		}
+		r.chunkState = chunkStateSize
+		return len(crlf), nil
+	case chunkStateTrailers:
+		if len(data) == 0 {
+			return 0, nil
+		}
+		n, done, err := r.Trailers.Parse(data)
+		if err != nil {
+			return n, fmt.Errorf("invalid trailer section: %v", err)
+		}
+		r.trailerBytes += n
+		unfinished := 0
+		if !done {
+			// The rest of data is a trailer line still waiting for its CRLF
+			unfinished = len(data) - n
+		}
+		if r.trailerBytes+unfinished > maxTrailerBytes {
+			return n, fmt.Errorf("trailer section longer than %d bytes", maxTrailerBytes)
+		}
+		if done {
+			r.ParserState = requestStateDone
+		}
+		return n, nil
+	default:
+		return 0, fmt.Errorf("error: unknown chunk state")
+	}
+}
+
+func parseChunkSize(line []byte) (int64, error) {
+	if bytes.ContainsAny(line, "\r\n\x00") {
+		return 0, errors.New("invalid character in chunk size line")
+	}
+	// chunk extensions are ignored
+	if i := bytes.IndexByte(line, ';'); i != -1 {
+		line = bytes.TrimRight(line[:i], " \t")
+	}
+	if len(line) == 0 || len(line) > maxChunkSizeDigits {
+		return 0, fmt.Errorf("invalid chunk size: %q", line)
+	}
+	for _, c := range line {
+		isHex := (c >= '0' && c <= '9') || (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F')
+		if !isHex {
+			return 0, fmt.Errorf("invalid chunk size: %q", line)
+		}
+	}
+	size, err := strconv.ParseInt(string(line), 16, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid chunk size: %v", err)
+	}
+	return size, nil
+}
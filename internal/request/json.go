@@ -0,0 +1,110 @@
+package request
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+const defaultMaxJSONBytes = 1 << 20
+
+// JSONOptions controls DecodeJSON. A zero MaxBytes means 1 MiB.
+type JSONOptions struct {
+	MaxBytes              int64
+	DisallowUnknownFields bool
+}
+
+// JSONError explains why a body could not be decoded. Status is the response
+// code that fits: 400, 413 or 415.
+type JSONError struct {
+	Status int
+	Detail string
+}
+
+func (e *JSONError) Error() string {
+	return e.Detail
+}
+
+var errJSONTooLarge = errors.New("body too large")
+
+// DecodeJSON decodes a single JSON value from the body into v. The body must
+// be declared as application/json or a +json type. MaxBytes is enforced while
+// the body is streamed, so an oversized body is never held in memory; one
+// whose Content-Length is already too large is refused without reading it.
+// Failures are *JSONError.
+func (r *Request) DecodeJSON(v any, opts JSONOptions) error {
+	maxBytes := opts.MaxBytes
+	if maxBytes == 0 {
+		maxBytes = defaultMaxJSONBytes
+	}
+
+	mediaType, _, err := r.Headers.ContentType()
+	if err != nil || (mediaType != "application/json" && !strings.HasSuffix(mediaType, "+json")) {
+		return &JSONError{Status: 415, Detail: fmt.Sprintf("Content-Type must be application/json, got %q", r.Headers.Get("Content-Type"))}
+	}
+	if r.contentLength > maxBytes {
+		return &JSONError{Status: 413, Detail: fmt.Sprintf("body must not be larger than %d bytes", maxBytes)}
+	}
+
+	decoder := json.NewDecoder(&jsonLimitReader{reader: r.BodyReader(), remaining: maxBytes})
+	if opts.DisallowUnknownFields {
+		decoder.DisallowUnknownFields()
+	}
+	err = decoder.Decode(v)
+	if err == nil {
+		// Only one value is allowed
+		err = decoder.Decode(&struct{}{})
+		if err == io.EOF {
+			return nil
+		}
+		if err == nil {
+			err = errors.New("body must contain a single JSON value")
+		}
+	}
+	return jsonError(err, maxBytes)
+}
+
+func jsonError(err error, maxBytes int64) *JSONError {
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case errors.Is(err, errJSONTooLarge):
+		return &JSONError{Status: 413, Detail: fmt.Sprintf("body must not be larger than %d bytes", maxBytes)}
+	case errors.Is(err, io.EOF):
+		return &JSONError{Status: 400, Detail: "body must not be empty"}
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		return &JSONError{Status: 400, Detail: "body contains badly-formed JSON"}
+	case errors.As(err, &syntaxErr):
+		return &JSONError{Status: 400, Detail: fmt.Sprintf("body contains badly-formed JSON at offset %d", syntaxErr.Offset)}
+	case errors.As(err, &typeErr):
+		return &JSONError{Status: 400, Detail: fmt.Sprintf("body has the wrong type for field %q", typeErr.Field)}
+	case strings.HasPrefix(err.Error(), "json: unknown field "):
+		return &JSONError{Status: 400, Detail: "body contains unknown field " + strings.TrimPrefix(err.Error(), "json: unknown field ")}
+	default:
+		return &JSONError{Status: 400, Detail: err.Error()}
+	}
+}
+
+// jsonLimitReader fails once more than remaining bytes have been read,
+// rather than silently truncating like io.LimitReader.
+type jsonLimitReader struct {
+	reader    io.Reader
+	remaining int64
+}
+
+func (l *jsonLimitReader) Read(p []byte) (int, error) {
+	if l.remaining < 0 {
+		return 0, errJSONTooLarge
+	}
+	if int64(len(p)) > l.remaining+1 {
+		p = p[:l.remaining+1]
+	}
+	n, err := l.reader.Read(p)
+	l.remaining -= int64(n)
+	if l.remaining < 0 {
+		return n, errJSONTooLarge
+	}
+	return n, err
+}
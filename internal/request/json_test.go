@@ -0,0 +1,75 @@
+package request
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type jsonPayload struct {
+	Name  string `json:"name"`
+	Count int    `json:"count"`
+}
+
+func jsonRequest(t *testing.T, contentType, body string) *Request {
+	t.Helper()
+	r, err := RequestFromReader(strings.NewReader(fmt.Sprintf(
+		"POST /items HTTP/1.1\r\nHost: localhost:42069\r\nContent-Type: %s\r\nContent-Length: %d\r\n\r\n%s",
+		contentType, len(body), body)))
+	require.NoError(t, err)
+	return r
+}
+
+func TestDecodeJSON(t *testing.T) {
+	// Test: Valid body
+	var payload jsonPayload
+	err := jsonRequest(t, "application/json; charset=utf-8", `{"name":"gopher","count":3}`).DecodeJSON(&payload, JSONOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, jsonPayload{Name: "gopher", Count: 3}, payload)
+
+	// Test: +json media type
+	err = jsonRequest(t, "application/merge-patch+json", `{"name":"x"}`).DecodeJSON(&payload, JSONOptions{})
+	require.NoError(t, err)
+
+	failures := []struct {
+		name        string
+		contentType string
+		body        string
+		opts        JSONOptions
+		status      int
+	}{
+		{"wrong content type", "text/plain", `{}`, JSONOptions{}, 415},
+		{"too large", "application/json", `{"name":"a long name"}`, JSONOptions{MaxBytes: 8}, 413},
+		{"empty", "application/json", ``, JSONOptions{}, 400},
+		{"malformed", "application/json", `{"name":`, JSONOptions{}, 400},
+		{"syntax", "application/json", `{"name" "x"}`, JSONOptions{}, 400},
+		{"wrong type", "application/json", `{"count":"three"}`, JSONOptions{}, 400},
+		{"unknown field", "application/json", `{"colour":"red"}`, JSONOptions{DisallowUnknownFields: true}, 400},
+		{"two values", "application/json", `{}{}`, JSONOptions{}, 400},
+	}
+	for _, tc := range failures {
+		err = jsonRequest(t, tc.contentType, tc.body).DecodeJSON(&payload, tc.opts)
+		var jsonErr *JSONError
+		require.ErrorAs(t, err, &jsonErr, tc.name)
+		assert.Equal(t, tc.status, jsonErr.Status, tc.name)
+	}
+
+	// Test: Size limit on a chunked body with no declared length
+	r, err := RequestFromReader(strings.NewReader("POST / HTTP/1.1\r\nHost: localhost:42069\r\nContent-Type: application/json\r\nTransfer-Encoding: chunked\r\n\r\n10\r\n{\"name\":\"abcdef\"\r\n1\r\n}\r\n0\r\n\r\n"))
+	require.NoError(t, err)
+	err = r.DecodeJSON(&payload, JSONOptions{MaxBytes: 10})
+	var jsonErr *JSONError
+	require.ErrorAs(t, err, &jsonErr)
+	assert.Equal(t, 413, jsonErr.Status)
+
+	// Test: An oversized declared length is refused before any body is read
+	r, err = NewReader(strings.NewReader("POST / HTTP/1.1\r\nHost: localhost:42069\r\nContent-Type: application/json\r\nContent-Length: 2147483648\r\n\r\n{")).Next()
+	require.NoError(t, err)
+	err = r.DecodeJSON(&payload, JSONOptions{})
+	require.ErrorAs(t, err, &jsonErr)
+	assert.Equal(t, 413, jsonErr.Status)
+	assert.True(t, r.BodyPending())
+}
@@ -0,0 +1,164 @@
+package request
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/5tuartw/httpfromtcp/internal/headers"
+)
+
+const bufferSize = 8
+
+// Reader parses consecutive requests from a single connection, keeping any
+// bytes read past the end of one request for the next, so pipelined requests
+// arriving in the same read are not lost.
+type Reader struct {
+	reader      io.Reader
+	buf         []byte
+	readToIndex int
+	current     *Request
+	// filledAt is when the buffered bytes were last added to.
+	filledAt time.Time
+}
+
+func NewReader(reader io.Reader) *Reader {
+	return &Reader{
+		reader: reader,
+		buf:    make([]byte, bufferSize),
+	}
+}
+
+// Next parses the request line and headers of the next request. The body is
+// left on the connection for ReadBody or BodyReader; whatever the caller did
+// not read is skipped before the following request is parsed, unless the
+// client is still waiting for a 100 Continue to send it.
+func (rr *Reader) Next() (*Request, error) {
+	if rr.current != nil && rr.current.BodyPending() {
+		if rr.current.awaitingContinue() {
+			return nil, errors.New("previous request body was never requested")
+		}
+		rr.current.bodyReadHandler = nil
+		_, err := io.Copy(io.Discard, rr.current.BodyReader())
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	request := &Request{
+		ParserState: requestStateInitialised,
+		Headers:     headers.Headers{},
+		source:      rr,
+	}
+	if rr.readToIndex > 0 {
+		// Pipelined behind the previous request
+		request.ReceivedAt = rr.filledAt
+	}
+	rr.current = request
+
+	err := rr.read(request)
+	if err != nil {
+		return nil, err
+	}
+
+	err = request.validate()
+	if err != nil {
+		return nil, err
+	}
+
+	return request, nil
+}
+
+// read feeds buffered and newly read data to the parser until the request is
+// done or is waiting for the caller to ask for the body.
+func (rr *Reader) read(r *Request) error {
+	for {
+		err := rr.parseBuffered(r)
+		if err != nil {
+			return err
+		}
+
+		if r.ParserState == requestStateDone || r.bodyDeferred() {
+			return nil
+		}
+
+		err = rr.fill(r)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (rr *Reader) parseBuffered(r *Request) error {
+	if rr.readToIndex == 0 {
+		return nil
+	}
+	bytesConsumed, parseErr := r.Parse(rr.buf[:rr.readToIndex])
+	if parseErr != nil {
+		return parseErr
+	}
+
+	// remove consumed data from buffer
+	if bytesConsumed > 0 {
+		copy(rr.buf, rr.buf[bytesConsumed:rr.readToIndex])
+		rr.readToIndex -= bytesConsumed
+	}
+	return nil
+}
+
+// Fill does a single read from the underlying reader into the buffer, where
+// the next call to Next finds it. It lets a server notice the client closing
+// the connection while a handler runs, and must not be called while anything
+// else reads from rr.
+func (rr *Reader) Fill() error {
+	rr.grow()
+	n, err := rr.reader.Read(rr.buf[rr.readToIndex:])
+	rr.readToIndex += n
+	if n > 0 {
+		rr.filledAt = time.Now()
+	}
+	return err
+}
+
+// fill does a single read from the underlying reader into the buffer.
+func (rr *Reader) fill(r *Request) error {
+	rr.grow()
+
+	n, err := rr.reader.Read(rr.buf[rr.readToIndex:])
+	rr.readToIndex += n
+	if n > 0 {
+		rr.filledAt = time.Now()
+		if r.ReceivedAt.IsZero() {
+			r.ReceivedAt = rr.filledAt
+		}
+	}
+	if err != nil {
+		if err == io.EOF {
+			if n > 0 {
+				return nil
+			}
+			if r.ParserState == requestStateInitialised && rr.readToIndex == 0 {
+				// connection closed cleanly between requests
+				return io.EOF
+			}
+			return fmt.Errorf("incomplete request: reached EOF in state %s", r.ParserState)
+		}
+		started := r.ParserState != requestStateInitialised || rr.readToIndex > 0
+		if started && errors.Is(err, os.ErrDeadlineExceeded) {
+			return fmt.Errorf("%w: %w", ErrRequestTimeout, err)
+		}
+		return fmt.Errorf("error reading from reader: %w", err)
+	}
+	return nil
+}
+
+// grow doubles the buffer when it is full.
+func (rr *Reader) grow() {
+	if rr.readToIndex == len(rr.buf) {
+		newBuf := make([]byte, len(rr.buf)*2)
+		copy(newBuf, rr.buf)
+		rr.buf = newBuf
+	}
+}
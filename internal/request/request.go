@@ -2,11 +2,13 @@ package request
 
 import (
 	"bytes"
+	"context"
+	"crypto/tls"
 	"errors"
 	"fmt"
 	"io"
-	"strconv"
 	"strings"
+	"time"
 	"unicode"
 
 	"github.com/5tuartw/httpfromtcp/internal/headers"
@@ -41,6 +43,39 @@ type Request struct {
 	ParserState Status
 	Headers     headers.Headers
 	Body        []byte
+	Trailers    headers.Headers
+
+	// Connection metadata, set by the server.
+	RemoteAddr string
+	LocalAddr  string
+	ConnID     uint64
+	// Sequence numbers the requests on a connection from 1.
+	Sequence int
+	// TLS is the connection's TLS state, or nil for plain TCP.
+	TLS *tls.ConnectionState
+	// ReceivedAt is when the first byte of the request was read, set by
+	// the Reader.
+	ReceivedAt time.Time
+
+	// The effective client IP, scheme and host. The server sets them from
+	// the connection and Host field; a forwarded-header resolver may replace
+	// them with what trusted proxies report.
+	ClientAddr string
+	Scheme     string
+	Host       string
+
+	chunked        bool
+	contentLength  int64
+	bodyReceived   int64
+	chunkState     chunkState
+	chunkRemaining int64
+	trailerBytes   int
+
+	source          *Reader
+	bodyRequested   bool
+	continueHandler func() error
+	bodyReadHandler func()
+	ctx             context.Context
 }
 
 type RequestLine struct {
@@ -50,70 +85,246 @@ type RequestLine struct {
 }
 
 const crlf = "\r\n"
-const bufferSize = 8
 
+// Parse errors that call for a response other than 400 Bad Request.
+var (
+	ErrRequestTimeout            = errors.New("timed out reading request")
+	ErrUnsupportedVersion        = errors.New("unsupported HTTP version")
+	ErrUnsupportedTransferCoding = errors.New("unsupported transfer coding")
+)
+
+// RequestFromReader parses a request and its body from reader. When the
+// client sent "Expect: 100-continue" the body is left unread and must be
+// fetched with ReadBody, so the client is only asked for it once it is
+// actually needed. Anything read past the end of the request is discarded;
+// use a Reader to parse several requests from one connection.
 func RequestFromReader(reader io.Reader) (*Request, error) {
-	buf := make([]byte, bufferSize)
-	readToIndex := 0
+	r, err := NewReader(reader).Next()
+	if err != nil {
+		return nil, err
+	}
+	if !r.awaitingContinue() {
+		_, err = r.ReadBody()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return r, nil
+}
 
-	request := &Request{
-		ParserState: requestStateInitialised,
-		Headers:     make(headers.Headers),
+func (r *Request) validate() error {
+	validMethod := validateMethod(r.RequestLine.Method)
+	if !validMethod {
+		return fmt.Errorf("invalid method: %s ", r.RequestLine.Method)
 	}
 
-	for request.ParserState != requestStateDone {
-		// If buffer is full, grow it
-		if readToIndex == len(buf) {
-			newBuf := make([]byte, len(buf)*2)
-			copy(newBuf, buf)
-			buf = newBuf
-		}
+	// A second Host could be read differently by a proxy in front of us
+	if len(r.Headers.Values("Host")) > 1 {
+		return errors.New("more than one Host header")
+	}
 
-		n, err := reader.Read(buf[readToIndex:])
-		if err != nil {
-			if err == io.EOF {
-				break
-			}
-			return nil, fmt.Errorf("error reading from reader: %v", err)
+	switch r.RequestLine.HttpVersion {
+	case "1.1":
+		if r.Headers.Get("Host") == "" {
+			return errors.New("missing or empty Host header in HTTP/1.1 request")
 		}
-		readToIndex += n
+	case "1.0":
+	default:
+		return fmt.Errorf("%w: %s", ErrUnsupportedVersion, r.RequestLine.HttpVersion)
+	}
 
-		bytesConsumed, parseErr := request.Parse(buf[:readToIndex])
-		if parseErr != nil {
-			return nil, parseErr
-		}
+	return nil
+}
 
-		// remove consumed data from buffer
-		if bytesConsumed > 0 {
-			copy(buf, buf[bytesConsumed:readToIndex])
-			readToIndex -= bytesConsumed
-		}
+// ExpectsContinue reports whether the client is waiting for a 100 Continue
+// interim response before sending the body. HTTP/1.0 clients never are.
+func (r *Request) ExpectsContinue() bool {
+	if r.RequestLine.HttpVersion == "1.0" {
+		return false
+	}
+	return strings.EqualFold(r.Headers.Get("Expect"), "100-continue")
+}
 
-		if err == io.EOF {
-			break
+// KeepAlive reports whether the client wants the connection kept open after
+// this request: the default for HTTP/1.1, opt-in with Connection: keep-alive
+// for HTTP/1.0.
+func (r *Request) KeepAlive() bool {
+	keepAlive := r.RequestLine.HttpVersion == "1.1"
+	for _, option := range strings.Split(r.Headers.Get("Connection"), ",") {
+		switch strings.ToLower(strings.TrimSpace(option)) {
+		case "close":
+			return false
+		case "keep-alive":
+			keepAlive = true
 		}
 	}
+	return keepAlive
+}
+
+// BodyPending reports whether the body has not been read from the connection yet.
+func (r *Request) BodyPending() bool {
+	return r.ParserState != requestStateDone
+}
 
-	if request.ParserState != requestStateDone {
-		return nil, fmt.Errorf("incomplete request: reached EOF before finding end of request line")
+// DiscardBody reads and drops up to max bytes of a body the handler left
+// unread, and reports whether the body is now finished. A body the client is
+// holding back until it gets a 100 Continue is never asked for.
+func (r *Request) DiscardBody(max int64) bool {
+	if !r.BodyPending() {
+		return true
+	}
+	if r.awaitingContinue() {
+		return false
 	}
+	_, err := io.Copy(io.Discard, io.LimitReader(r.BodyReader(), max))
+	return err == nil && !r.BodyPending()
+}
 
-	validMethod := validateMethod(request.RequestLine.Method)
-	if !validMethod {
-		return nil, fmt.Errorf("invalid method: %s ", request.RequestLine.Method)
+// SetContinueHandler registers fn to be called the first time the body of an
+// Expect: 100-continue request is read, normally to send the interim response.
+func (r *Request) SetContinueHandler(fn func() error) {
+	r.continueHandler = fn
+}
+
+// SetBodyReadHandler registers fn to be called once the body has been read
+// from the connection by ReadBody or BodyReader. Nothing else reads from the
+// Reader until the next call to Next, so fn may start using it.
+func (r *Request) SetBodyReadHandler(fn func()) {
+	r.bodyReadHandler = fn
+}
+
+// bodyRead calls the body read handler the first time the body is done.
+func (r *Request) bodyRead() {
+	if r.ParserState != requestStateDone || r.bodyReadHandler == nil {
+		return
+	}
+	fn := r.bodyReadHandler
+	r.bodyReadHandler = nil
+	fn()
+}
+
+// Context returns the request's context. The server cancels it when the
+// client disconnects, the server is closed or the handler returns.
+func (r *Request) Context() context.Context {
+	if r.ctx == nil {
+		return context.Background()
+	}
+	return r.ctx
+}
+
+// SetContext replaces the request's context, so middleware can add a
+// deadline or request-scoped values for the handlers it calls. ctx should be
+// derived from the current context so it is still cancelled with it.
+func (r *Request) SetContext(ctx context.Context) {
+	r.ctx = ctx
+}
+
+// ReadBody returns the request body, reading all of it from the connection
+// first if it is still there.
+func (r *Request) ReadBody() ([]byte, error) {
+	if r.ParserState == requestStateDone {
+		return r.Body, nil
+	}
+	err := r.requestBody()
+	if err != nil {
+		return nil, err
+	}
+
+	err = r.source.read(r)
+	if err != nil {
+		return nil, err
+	}
+	r.bodyRead()
+	return r.Body, nil
+}
+
+// BodyReader returns the body as a stream. A body still on the connection is
+// handed out as it arrives rather than collected in Body, so large uploads
+// are never held in memory all at once; Body is left empty afterwards.
+func (r *Request) BodyReader() io.Reader {
+	if r.ParserState == requestStateDone {
+		return bytes.NewReader(r.Body)
+	}
+	return &bodyReader{r: r}
+}
+
+type bodyReader struct {
+	r *Request
+}
+
+func (br *bodyReader) Read(p []byte) (int, error) {
+	n, err := br.read(p)
+	br.r.bodyRead()
+	return n, err
+}
+
+func (br *bodyReader) read(p []byte) (int, error) {
+	r := br.r
+	if len(r.Body) == 0 && r.ParserState != requestStateDone {
+		err := r.requestBody()
+		if err != nil {
+			return 0, err
+		}
+	}
+	for len(r.Body) == 0 {
+		// Once done the Reader may already be in use for what follows
+		if r.ParserState == requestStateDone {
+			return 0, io.EOF
+		}
+		err := r.source.parseBuffered(r)
+		if err != nil {
+			return 0, err
+		}
+		if len(r.Body) > 0 || r.ParserState == requestStateDone {
+			continue
+		}
+		err = r.source.fill(r)
+		if err != nil {
+			return 0, err
+		}
 	}
+	n := copy(p, r.Body)
+	r.Body = r.Body[n:]
+	if len(r.Body) == 0 {
+		r.Body = nil
+	}
+	return n, nil
+}
 
-	if !(request.RequestLine.HttpVersion == "1.1") {
-		return nil, errors.New("only HTTP/1.1 is supported")
+// requestBody sends the 100 Continue the first time the body is wanted.
+func (r *Request) requestBody() error {
+	if r.bodyRequested {
+		return nil
+	}
+	r.bodyRequested = true
+	if r.continueHandler != nil && r.ExpectsContinue() {
+		err := r.continueHandler()
+		if err != nil {
+			return fmt.Errorf("error sending continue: %v", err)
+		}
 	}
+	return nil
+}
 
-	return request, nil
+// bodyDeferred reports whether parsing stopped at the end of the headers
+// because nobody has asked for the body yet.
+func (r *Request) bodyDeferred() bool {
+	if r.ParserState != requestStateParsingBody || r.bodyRequested {
+		return false
+	}
+	return r.chunked || r.contentLength > 0
+}
+
+// awaitingContinue reports whether the client will not send the body until
+// it gets a 100 Continue.
+func (r *Request) awaitingContinue() bool {
+	return r.bodyDeferred() && r.ExpectsContinue()
 }
 
 func (r *Request) Parse(data []byte) (int, error) {
 	totalBytesParsed := 0
 
-	for r.ParserState != requestStateDone {
+	for r.ParserState != requestStateDone && !r.bodyDeferred() {
 		n, err := r.parseSingle(data[totalBytesParsed:])
 		if err != nil {
 			return totalBytesParsed, err
@@ -149,32 +360,34 @@ func (r *Request) parseSingle(data []byte) (int, error) {
 			return bytesRead, err
 		}
 		if isDone {
+			err = r.setFraming()
+			if err != nil {
+				return bytesRead, err
+			}
 			r.ParserState = requestStateParsingBody
 		}
 		return bytesRead, nil
 	} else if r.ParserState == requestStateParsingBody {
-		length := r.Headers.Get("Content-Length")
-		if length == "" {
+		if r.chunked {
+			return r.parseChunked(data)
+		}
+		if r.contentLength < 0 {
 			r.ParserState = requestStateDone
 			return 0, nil
 		}
 
-		contentLength, err := strconv.Atoi(length)
-		if err != nil {
-			return 0, fmt.Errorf("invalid Content-Length: %v", err)
+		// Anything past the declared length belongs to the next request
+		remaining := r.contentLength - r.bodyReceived
+		if int64(len(data)) > remaining {
+			data = data[:remaining]
 		}
-
-		// Append the current chunk to the body
 		r.Body = append(r.Body, data...)
+		r.bodyReceived += int64(len(data))
 
-		// Check if we've read enough bytes
-		if len(r.Body) > contentLength {
-			return 0, fmt.Errorf("body length exceeds content length")
-		} else if len(r.Body) == contentLength {
+		if r.bodyReceived == r.contentLength {
 			r.ParserState = requestStateDone
 		}
 
-		// Return that we've consumed all the data in this chunk
 		return len(data), nil
 	} else if r.ParserState == requestStateDone {
 		return 0, fmt.Errorf("error: trying to read data in a done state")
@@ -186,8 +399,14 @@ func (r *Request) parseSingle(data []byte) (int, error) {
 func parseRequestLine(rLine []byte) (*RequestLine, int, error) {
 	idx := bytes.Index(rLine, []byte(crlf))
 	if idx == -1 {
+		if bytes.IndexByte(rLine, '\n') != -1 {
+			return nil, 0, errors.New("bare LF in request line")
+		}
 		return nil, 0, nil
 	}
+	if bytes.ContainsAny(rLine[:idx], "\r\x00") {
+		return nil, 0, errors.New("CR or NUL in request line")
+	}
 	requestLineText := string(rLine[:idx])
 
 	requestLine, err := requestLineFromString(requestLineText)
@@ -1,9 +1,12 @@
 package request
 
 import (
+	"context"
 	"io"
+	"net"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -80,6 +83,26 @@ func (cr *chunkReader) Read(p []byte) (n int, err error) {
 	return n, nil
 }
 
+// endlessReader sends prefix followed by filler repeated forever, counting
+// what it handed out.
+type endlessReader struct {
+	prefix string
+	filler string
+	pos    int
+	read   int
+}
+
+func (er *endlessReader) Read(p []byte) (int, error) {
+	n := copy(p, er.prefix)
+	er.prefix = er.prefix[n:]
+	for ; n < len(p); n++ {
+		p[n] = er.filler[er.pos%len(er.filler)]
+		er.pos++
+	}
+	er.read += n
+	return n, nil
+}
+
 func TestHeaderParsing(t *testing.T) {
 	// Test: Standard Headers
 	reader := &chunkReader{
@@ -89,9 +112,9 @@ func TestHeaderParsing(t *testing.T) {
 	r, err := RequestFromReader(reader)
 	require.NoError(t, err)
 	require.NotNil(t, r)
-	assert.Equal(t, "localhost:42069", r.Headers["host"])
-	assert.Equal(t, "curl/7.81.0", r.Headers["user-agent"])
-	assert.Equal(t, "*/*", r.Headers["accept"])
+	assert.Equal(t, "localhost:42069", r.Headers.Get("host"))
+	assert.Equal(t, "curl/7.81.0", r.Headers.Get("user-agent"))
+	assert.Equal(t, "*/*", r.Headers.Get("accept"))
 
 	// Test: Malformed Header
 	reader = &chunkReader{
@@ -103,26 +126,25 @@ func TestHeaderParsing(t *testing.T) {
 
 	// Test: Empty headers
 	reader = &chunkReader{
-		data:            "GET / HTTP/1.1\r\nHost:\r\nUser-Agent:\r\nAccept:\r\n\r\n",
+		data:            "GET / HTTP/1.1\r\nHost: localhost:42069\r\nUser-Agent:\r\nAccept:\r\n\r\n",
 		numBytesPerRead: 3,
 	}
 	r, err = RequestFromReader(reader)
 	require.NoError(t, err)
 	require.NotNil(t, r)
-	assert.Equal(t, "", r.Headers["host"])
-	assert.Equal(t, "", r.Headers["user-agent"])
-	assert.Equal(t, "", r.Headers["accept"])
+	assert.Equal(t, "", r.Headers.Get("user-agent"))
+	assert.Equal(t, "", r.Headers.Get("accept"))
 
 	// Test: Duplicate Headers
 	reader = &chunkReader{
-		data:            "GET / HTTP/1.1\r\nHost: localhost:42069\r\nHost: localhost:8080\r\nAccept: */*\r\n\r\n",
+		data:            "GET / HTTP/1.1\r\nHost: localhost:42069\r\nAccept: text/html\r\nAccept: */*\r\n\r\n",
 		numBytesPerRead: 3,
 	}
 	r, err = RequestFromReader(reader)
 	require.NoError(t, err)
 	require.NotNil(t, r)
-	assert.Equal(t, "localhost:42069, localhost:8080", r.Headers["host"])
-	assert.Equal(t, "*/*", r.Headers["accept"])
+	assert.Equal(t, "localhost:42069", r.Headers.Get("host"))
+	assert.Equal(t, "text/html, */*", r.Headers.Get("accept"))
 
 	// Test: Case Insensitive Headers
 	reader = &chunkReader{
@@ -132,9 +154,9 @@ func TestHeaderParsing(t *testing.T) {
 	r, err = RequestFromReader(reader)
 	require.NoError(t, err)
 	require.NotNil(t, r)
-	assert.Equal(t, "localhost:42069", r.Headers["host"])
-	assert.Equal(t, "curl/7.81.0", r.Headers["user-agent"])
-	assert.Equal(t, "*/*", r.Headers["accept"])
+	assert.Equal(t, "localhost:42069", r.Headers.Get("host"))
+	assert.Equal(t, "curl/7.81.0", r.Headers.Get("user-agent"))
+	assert.Equal(t, "*/*", r.Headers.Get("accept"))
 
 	// Test: Missing End of Headers
 	reader = &chunkReader{
@@ -213,3 +235,285 @@ func TestBodyParsing(t *testing.T) {
 	require.Error(t, err)
 
 }
+
+func TestExpectContinue(t *testing.T) {
+	// Test: Body deferred until ReadBody, continue handler called once
+	reader := &chunkReader{
+		data: "POST /upload HTTP/1.1\r\n" +
+			"Host: localhost:42069\r\n" +
+			"Expect: 100-continue\r\n" +
+			"Content-Length: 13\r\n" +
+			"\r\n" +
+			"hello world!\n",
+		numBytesPerRead: 3,
+	}
+	r, err := RequestFromReader(reader)
+	require.NoError(t, err)
+	require.NotNil(t, r)
+	assert.True(t, r.ExpectsContinue())
+	assert.True(t, r.BodyPending())
+	assert.Empty(t, r.Body)
+
+	continues := 0
+	r.SetContinueHandler(func() error {
+		continues++
+		return nil
+	})
+	body, err := r.ReadBody()
+	require.NoError(t, err)
+	assert.Equal(t, "hello world!\n", string(body))
+	assert.False(t, r.BodyPending())
+	body, err = r.ReadBody()
+	require.NoError(t, err)
+	assert.Equal(t, "hello world!\n", string(body))
+	assert.Equal(t, 1, continues)
+
+	// Test: Expect with no body does not wait
+	reader = &chunkReader{
+		data: "POST /upload HTTP/1.1\r\n" +
+			"Host: localhost:42069\r\n" +
+			"Expect: 100-continue\r\n" +
+			"Content-Length: 0\r\n" +
+			"\r\n",
+		numBytesPerRead: 3,
+	}
+	r, err = RequestFromReader(reader)
+	require.NoError(t, err)
+	assert.False(t, r.BodyPending())
+
+	// Test: Body read without Expect is available immediately
+	r, err = RequestFromReader(strings.NewReader("POST /upload HTTP/1.1\r\nHost: localhost:42069\r\nContent-Length: 2\r\n\r\nhi"))
+	require.NoError(t, err)
+	assert.Equal(t, "hi", string(r.Body))
+	body, err = r.ReadBody()
+	require.NoError(t, err)
+	assert.Equal(t, "hi", string(body))
+}
+
+func TestHttpVersions(t *testing.T) {
+	// Test: HTTP/1.0 without Host
+	r, err := RequestFromReader(strings.NewReader("GET / HTTP/1.0\r\n\r\n"))
+	require.NoError(t, err)
+	require.NotNil(t, r)
+	assert.Equal(t, "1.0", r.RequestLine.HttpVersion)
+	assert.False(t, r.KeepAlive())
+
+	// Test: HTTP/1.0 keep-alive opt-in
+	r, err = RequestFromReader(strings.NewReader("GET / HTTP/1.0\r\nConnection: Keep-Alive\r\n\r\n"))
+	require.NoError(t, err)
+	assert.True(t, r.KeepAlive())
+
+	// Test: HTTP/1.0 ignores Expect
+	r, err = RequestFromReader(strings.NewReader("POST / HTTP/1.0\r\nExpect: 100-continue\r\nContent-Length: 2\r\n\r\nhi"))
+	require.NoError(t, err)
+	assert.False(t, r.ExpectsContinue())
+	assert.Equal(t, "hi", string(r.Body))
+
+	// Test: HTTP/1.1 defaults to keep-alive
+	r, err = RequestFromReader(strings.NewReader("GET / HTTP/1.1\r\nHost: localhost:42069\r\n\r\n"))
+	require.NoError(t, err)
+	assert.True(t, r.KeepAlive())
+
+	// Test: HTTP/1.1 close
+	r, err = RequestFromReader(strings.NewReader("GET / HTTP/1.1\r\nHost: localhost:42069\r\nConnection: close\r\n\r\n"))
+	require.NoError(t, err)
+	assert.False(t, r.KeepAlive())
+
+	// Test: HTTP/1.1 requires Host
+	_, err = RequestFromReader(strings.NewReader("GET / HTTP/1.1\r\nAccept: */*\r\n\r\n"))
+	require.Error(t, err)
+
+	// Test: Clean EOF before any request
+	_, err = RequestFromReader(strings.NewReader(""))
+	require.ErrorIs(t, err, io.EOF)
+}
+
+func TestChunkedBodyParsing(t *testing.T) {
+	// Test: Chunked body with extension and trailer
+	reader := &chunkReader{
+		data: "POST /submit HTTP/1.1\r\n" +
+			"Host: localhost:42069\r\n" +
+			"Transfer-Encoding: chunked\r\n" +
+			"\r\n" +
+			"6;name=value\r\nhello \r\n" +
+			"7\r\nworld!\n\r\n" +
+			"0\r\n" +
+			"X-Checksum: abc\r\n" +
+			"\r\n",
+		numBytesPerRead: 3,
+	}
+	r, err := RequestFromReader(reader)
+	require.NoError(t, err)
+	require.NotNil(t, r)
+	assert.Equal(t, "hello world!\n", string(r.Body))
+	assert.Equal(t, "abc", r.Trailers.Get("X-Checksum"))
+
+	// Test: Chunk data longer than its size
+	_, err = RequestFromReader(strings.NewReader("POST / HTTP/1.1\r\nHost: localhost:42069\r\nTransfer-Encoding: chunked\r\n\r\n2\r\nhello\r\n0\r\n\r\n"))
+	require.Error(t, err)
+
+	// Test: Missing last chunk
+	_, err = RequestFromReader(strings.NewReader("POST / HTTP/1.1\r\nHost: localhost:42069\r\nTransfer-Encoding: chunked\r\n\r\n2\r\nhi\r\n"))
+	require.Error(t, err)
+
+	// Test: Overflowing chunk size
+	_, err = RequestFromReader(strings.NewReader("POST / HTTP/1.1\r\nHost: localhost:42069\r\nTransfer-Encoding: chunked\r\n\r\nffffffffffffffffff\r\n"))
+	require.Error(t, err)
+}
+
+func TestRequestSmuggling(t *testing.T) {
+	badRequests := map[string]string{
+		"differing Content-Length":   "POST / HTTP/1.1\r\nHost: localhost:42069\r\nContent-Length: 3\r\nContent-Length: 4\r\n\r\nabcd",
+		"Content-Length list":        "POST / HTTP/1.1\r\nHost: localhost:42069\r\nContent-Length: 3, 4\r\n\r\nabcd",
+		"negative Content-Length":    "POST / HTTP/1.1\r\nHost: localhost:42069\r\nContent-Length: -1\r\n\r\n",
+		"signed Content-Length":      "POST / HTTP/1.1\r\nHost: localhost:42069\r\nContent-Length: +3\r\n\r\nabc",
+		"overflowing Content-Length": "POST / HTTP/1.1\r\nHost: localhost:42069\r\nContent-Length: 99999999999999999999\r\n\r\n",
+		"CL and TE":                  "POST / HTTP/1.1\r\nHost: localhost:42069\r\nContent-Length: 3\r\nTransfer-Encoding: chunked\r\n\r\n0\r\n\r\n",
+		"non-final chunked":          "POST / HTTP/1.1\r\nHost: localhost:42069\r\nTransfer-Encoding: chunked, gzip\r\n\r\n0\r\n\r\n",
+		"repeated chunked":           "POST / HTTP/1.1\r\nHost: localhost:42069\r\nTransfer-Encoding: chunked\r\nTransfer-Encoding: chunked\r\n\r\n0\r\n\r\n",
+		"unknown coding":             "POST / HTTP/1.1\r\nHost: localhost:42069\r\nTransfer-Encoding: identity\r\n\r\n",
+		"TE in HTTP/1.0":             "POST / HTTP/1.0\r\nTransfer-Encoding: chunked\r\n\r\n0\r\n\r\n",
+		"bare LF request line":       "GET / HTTP/1.1\nHost: localhost:42069\r\n\r\n",
+		"bare LF header":             "GET / HTTP/1.1\r\nHost: localhost:42069\nContent-Length: 5\r\n\r\n",
+		"obs-fold":                   "GET / HTTP/1.1\r\nHost: localhost:42069\r\nX-Folded: a\r\n b\r\n\r\n",
+		"space in field name":        "GET / HTTP/1.1\r\nHost: localhost:42069\r\nContent Length: 5\r\n\r\n",
+		"two Host lines":             "GET / HTTP/1.1\r\nHost: a.example\r\nHost: b.example\r\n\r\n",
+		"two Host lines in HTTP/1.0": "GET / HTTP/1.0\r\nHost: a.example\r\nHost: b.example\r\n\r\n",
+		"empty Host":                 "GET / HTTP/1.1\r\nHost: \r\n\r\n",
+	}
+	for name, data := range badRequests {
+		_, err := RequestFromReader(&chunkReader{data: data, numBytesPerRead: 3})
+		assert.Error(t, err, name)
+	}
+
+	// Test: An endless chunk extension or trailer fails without reading on
+	chunked := "POST / HTTP/1.1\r\nHost: localhost:42069\r\nTransfer-Encoding: chunked\r\n\r\n"
+	for name, reader := range map[string]*endlessReader{
+		"chunk extension": {prefix: chunked + "1;ext=", filler: "a"},
+		"trailer":         {prefix: chunked + "0\r\nX-Trailer: ", filler: "a"},
+		"trailer lines":   {prefix: chunked + "0\r\n", filler: "X-Trailer: a\r\n"},
+	} {
+		_, err := RequestFromReader(reader)
+		assert.Error(t, err, name)
+		assert.Less(t, reader.read, 64<<10, name)
+	}
+
+	// Test: Identical duplicate Content-Length is accepted
+	r, err := RequestFromReader(strings.NewReader("POST / HTTP/1.1\r\nHost: localhost:42069\r\nContent-Length: 3\r\nContent-Length: 3\r\n\r\nabc"))
+	require.NoError(t, err)
+	assert.Equal(t, "abc", string(r.Body))
+}
+
+func TestPipelinedRequests(t *testing.T) {
+	// Test: Several requests read from one stream
+	reader := NewReader(&chunkReader{
+		data: "POST /first HTTP/1.1\r\nHost: localhost:42069\r\nContent-Length: 5\r\n\r\nhello" +
+			"GET /second HTTP/1.1\r\nHost: localhost:42069\r\n\r\n" +
+			"POST /third HTTP/1.1\r\nHost: localhost:42069\r\nTransfer-Encoding: chunked\r\n\r\n3\r\nabc\r\n0\r\n\r\n",
+		numBytesPerRead: 64,
+	})
+	r, err := reader.Next()
+	require.NoError(t, err)
+	assert.Equal(t, "/first", r.RequestLine.RequestTarget)
+	body, err := r.ReadBody()
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(body))
+
+	r, err = reader.Next()
+	require.NoError(t, err)
+	assert.Equal(t, "/second", r.RequestLine.RequestTarget)
+	assert.False(t, r.BodyPending())
+	assert.Empty(t, r.Body)
+
+	r, err = reader.Next()
+	require.NoError(t, err)
+	assert.Equal(t, "/third", r.RequestLine.RequestTarget)
+	body, err = r.ReadBody()
+	require.NoError(t, err)
+	assert.Equal(t, "abc", string(body))
+
+	_, err = reader.Next()
+	require.ErrorIs(t, err, io.EOF)
+
+	// Test: the body is left on the connection, and skipped if never read
+	reader = NewReader(strings.NewReader("POST / HTTP/1.1\r\nHost: localhost:42069\r\nContent-Length: 5\r\n\r\nhello" +
+		"GET /next HTTP/1.1\r\nHost: localhost:42069\r\n\r\n"))
+	r, err = reader.Next()
+	require.NoError(t, err)
+	assert.True(t, r.BodyPending())
+	assert.Empty(t, r.Body)
+	r, err = reader.Next()
+	require.NoError(t, err)
+	assert.Equal(t, "/next", r.RequestLine.RequestTarget)
+
+	// Test: Next refuses to skip an unread body
+	reader = NewReader(strings.NewReader("POST / HTTP/1.1\r\nHost: localhost:42069\r\nExpect: 100-continue\r\nContent-Length: 2\r\n\r\nhiGET / HTTP/1.1\r\nHost: localhost:42069\r\n\r\n"))
+	r, err = reader.Next()
+	require.NoError(t, err)
+	require.True(t, r.BodyPending())
+	_, err = reader.Next()
+	require.Error(t, err)
+	body, err = r.ReadBody()
+	require.NoError(t, err)
+	assert.Equal(t, "hi", string(body))
+	r, err = reader.Next()
+	require.NoError(t, err)
+	assert.Equal(t, "GET", r.RequestLine.Method)
+}
+
+func TestCookies(t *testing.T) {
+	r, err := RequestFromReader(strings.NewReader("GET / HTTP/1.1\r\nHost: localhost:42069\r\nCookie: session=abc; theme=dark\r\nCookie: lang=en\r\n\r\n"))
+	require.NoError(t, err)
+	cookies := r.Cookies()
+	require.Len(t, cookies, 3)
+	assert.Equal(t, "lang", cookies[2].Name)
+	c, ok := r.Cookie("theme")
+	assert.True(t, ok)
+	assert.Equal(t, "dark", c.Value)
+	_, ok = r.Cookie("missing")
+	assert.False(t, ok)
+}
+
+func TestReceivedAt(t *testing.T) {
+	clientConn, serverConn := net.Pipe()
+	defer clientConn.Close()
+	reader := NewReader(serverConn)
+	send := func(data string) {
+		go clientConn.Write([]byte(data))
+	}
+
+	// Test: stamped when the first byte is read, not when parsing ends
+	before := time.Now()
+	send("GET /first HTTP/1.1\r\nHost: localhost\r\n\r\n" +
+		"GET /second HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	first, err := reader.Next()
+	require.NoError(t, err)
+	assert.False(t, first.ReceivedAt.Before(before))
+
+	// Test: a pipelined request already buffered keeps the earlier read time
+	second, err := reader.Next()
+	require.NoError(t, err)
+	assert.Equal(t, "/second", second.RequestLine.RequestTarget)
+	assert.False(t, second.ReceivedAt.After(time.Now()))
+	assert.Less(t, second.ReceivedAt.Sub(first.ReceivedAt), 10*time.Millisecond)
+
+	time.Sleep(20 * time.Millisecond)
+	send("GET /third HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	third, err := reader.Next()
+	require.NoError(t, err)
+	assert.GreaterOrEqual(t, third.ReceivedAt.Sub(first.ReceivedAt), 20*time.Millisecond)
+}
+
+func TestContext(t *testing.T) {
+	r, err := RequestFromReader(strings.NewReader("GET / HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	require.NoError(t, err)
+
+	// Test: a parsed request has a background context
+	assert.Equal(t, context.Background(), r.Context())
+
+	// Test: SetContext replaces it
+	ctx, cancel := context.WithCancel(context.Background())
+	r.SetContext(ctx)
+	cancel()
+	assert.ErrorIs(t, r.Context().Err(), context.Canceled)
+}
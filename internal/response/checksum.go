@@ -0,0 +1,112 @@
+package response
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"strconv"
+	"strings"
+
+	"github.com/5tuartw/httpfromtcp/internal/headers"
+)
+
+type ChecksumAlgorithm int
+
+const (
+	ChecksumSHA256 ChecksumAlgorithm = iota
+	ChecksumCRC32C
+	ChecksumMD5
+)
+
+const checksumLengthTrailer = "X-Content-Length"
+
+var crc32cTable = crc32.MakeTable(crc32.Castagnoli)
+
+func (c ChecksumAlgorithm) String() string {
+	switch c {
+	case ChecksumSHA256:
+		return "SHA256"
+	case ChecksumCRC32C:
+		return "CRC32C"
+	case ChecksumMD5:
+		return "MD5"
+	default:
+		return fmt.Sprintf("ChecksumAlgorithm(%d)", c)
+	}
+}
+
+// TrailerName is the trailer field the digest is sent in, e.g. X-Content-SHA256.
+func (c ChecksumAlgorithm) TrailerName() string {
+	return "X-Content-" + c.String()
+}
+
+func (c ChecksumAlgorithm) newHash() (hash.Hash, error) {
+	switch c {
+	case ChecksumSHA256:
+		return sha256.New(), nil
+	case ChecksumCRC32C:
+		return crc32.New(crc32cTable), nil
+	case ChecksumMD5:
+		return md5.New(), nil
+	default:
+		return nil, fmt.Errorf("unknown checksum algorithm: %s", c)
+	}
+}
+
+// UseChecksumTrailers makes the writer declare a Trailer header and hash every
+// chunk passed to WriteChunkedBody, sending the hex digests and the body length
+// as trailers from WriteChunkedBodyDone. It must be called before WriteHeaders.
+func (w *Writer) UseChecksumTrailers(algorithms ...ChecksumAlgorithm) error {
+	if w.State != WritingInitialised && w.State != WritingStatusDone {
+		return fmt.Errorf("cannot enable checksum trailers while writer state is %s", w.State)
+	}
+	if len(algorithms) == 0 {
+		return fmt.Errorf("no checksum algorithms given")
+	}
+
+	hashes := make([]hash.Hash, 0, len(algorithms))
+	for _, algorithm := range algorithms {
+		h, err := algorithm.newHash()
+		if err != nil {
+			return err
+		}
+		hashes = append(hashes, h)
+	}
+
+	w.checksums = algorithms
+	w.hashes = hashes
+	w.bodyLength = 0
+	return nil
+}
+
+func (w *Writer) checksumTrailerNames() []string {
+	names := make([]string, 0, len(w.checksums)+1)
+	for _, algorithm := range w.checksums {
+		names = append(names, algorithm.TrailerName())
+	}
+	return append(names, checksumLengthTrailer)
+}
+
+// withTrailerDeclaration returns a copy of h with the checksum trailers added
+// to any Trailer field the caller already declared.
+func (w *Writer) withTrailerDeclaration(h headers.Headers) headers.Headers {
+	declared := strings.Join(w.checksumTrailerNames(), ", ")
+	newHeaders := h.Clone()
+	if value := newHeaders.Get("Trailer"); value != "" {
+		declared = value + ", " + declared
+	}
+	newHeaders.Set("Trailer", declared)
+	return newHeaders
+}
+
+func (w *Writer) writeChecksumTrailers() (int, error) {
+	var sb strings.Builder
+	for i, algorithm := range w.checksums {
+		sb.WriteString(algorithm.TrailerName() + ": " + hex.EncodeToString(w.hashes[i].Sum(nil)) + crlf)
+	}
+	sb.WriteString(checksumLengthTrailer + ": " + strconv.Itoa(w.bodyLength) + crlf)
+	return w.IoWriter.Write([]byte(sb.String()))
+}
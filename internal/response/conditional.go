@@ -0,0 +1,197 @@
+package response
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/5tuartw/httpfromtcp/internal/headers"
+	"github.com/5tuartw/httpfromtcp/internal/request"
+)
+
+// Validators describe the current representation of a resource. A zero
+// ETag or LastModified means the resource has no such validator.
+type Validators struct {
+	ETag         headers.ETag
+	LastModified time.Time
+}
+
+func (v Validators) hasETag() bool {
+	return v.ETag != headers.ETag{}
+}
+
+// SetHeaders adds the ETag and Last-Modified fields for v to h.
+func (v Validators) SetHeaders(h *headers.Headers) {
+	if v.hasETag() {
+		h.Set("ETag", v.ETag.String())
+	}
+	if !v.LastModified.IsZero() {
+		h.Set("Last-Modified", headers.FormatTime(v.LastModified))
+	}
+}
+
+// StrongETag derives a strong entity tag from a complete body.
+func StrongETag(body []byte) headers.ETag {
+	sum := sha256.Sum256(body)
+	return headers.ETag{Tag: hex.EncodeToString(sum[:16])}
+}
+
+// CheckPreconditions evaluates the conditional request fields against v in
+// the order of RFC 9110 section 13.2.2. If a condition fails it writes the
+// 304 Not Modified or 412 Precondition Failed response and returns false, so
+// handlers can simply return. Fields a 304 must repeat, such as
+// Cache-Control, can be given to it through w.Header().
+func CheckPreconditions(w *Writer, req *request.Request, v Validators) bool {
+	return checkPreconditions(w, req, v, nil)
+}
+
+// checkPreconditions is CheckPreconditions with the headers of the 200
+// response, some of which a 304 has to repeat.
+func checkPreconditions(w *Writer, req *request.Request, v Validators, h headers.Headers) bool {
+	safe := req.RequestLine.Method == "GET" || req.RequestLine.Method == "HEAD"
+
+	if req.Headers.Has("If-Match") {
+		if !v.ifMatch(req.Headers) {
+			return w.preconditionFailed(req, "If-Match")
+		}
+	} else if since, ok := req.Headers.Time("If-Unmodified-Since"); ok && !v.LastModified.IsZero() {
+		if v.modifiedSince(since) {
+			return w.preconditionFailed(req, "If-Unmodified-Since")
+		}
+	}
+
+	if req.Headers.Has("If-None-Match") {
+		if !v.ifNoneMatch(req.Headers) {
+			if safe {
+				return w.notModified(v, h)
+			}
+			return w.preconditionFailed(req, "If-None-Match")
+		}
+	} else if since, ok := req.Headers.Time("If-Modified-Since"); ok && safe && !v.LastModified.IsZero() {
+		if !v.modifiedSince(since) {
+			return w.notModified(v, h)
+		}
+	}
+	return true
+}
+
+// IfRange reports whether a Range in a GET request may be honoured, meaning
+// If-Range is absent or still matches the representation. Otherwise the
+// whole representation should be sent.
+func IfRange(req *request.Request, v Validators) bool {
+	value := req.Headers.Get("If-Range")
+	if value == "" {
+		return true
+	}
+	if etag, err := headers.ParseETag(value); err == nil {
+		return v.hasETag() && etag.StrongMatch(v.ETag)
+	}
+	// A date only validates when it is exactly the strong Last-Modified
+	date, err := headers.ParseTime(value)
+	if err != nil || v.LastModified.IsZero() {
+		return false
+	}
+	return date.Equal(v.LastModified.Truncate(time.Second))
+}
+
+// WriteConditional writes a complete 200 response with body, unless the
+// request's preconditions answer it with 304 or 412 instead. A strong ETag
+// is generated from the body when h does not carry one.
+func (w *Writer) WriteConditional(req *request.Request, h headers.Headers, body []byte) error {
+	v := Validators{}
+	if etag, err := headers.ParseETag(h.Get("ETag")); err == nil {
+		v.ETag = etag
+	} else {
+		v.ETag = StrongETag(body)
+	}
+	if lastModified, ok := h.Time("Last-Modified"); ok {
+		v.LastModified = lastModified
+	}
+	if !checkPreconditions(w, req, v, h) {
+		return nil
+	}
+
+	h = h.Clone()
+	v.SetHeaders(&h)
+	h.Set("Content-Length", strconv.Itoa(len(body)))
+	err := w.WriteStatusLine(OK)
+	if err != nil {
+		return err
+	}
+	err = w.WriteHeaders(h)
+	if err != nil {
+		return err
+	}
+	_, err = w.WriteBody(body)
+	return err
+}
+
+// ifMatch is true for "*" or when a listed tag strongly matches.
+func (v Validators) ifMatch(h headers.Headers) bool {
+	tags, any, err := h.ETags("If-Match")
+	if err != nil {
+		return false
+	}
+	if any {
+		return true
+	}
+	for _, tag := range tags {
+		if v.hasETag() && tag.StrongMatch(v.ETag) {
+			return true
+		}
+	}
+	return false
+}
+
+// ifNoneMatch is false for "*" or when a listed tag weakly matches. An
+// invalid field is ignored.
+func (v Validators) ifNoneMatch(h headers.Headers) bool {
+	tags, any, err := h.ETags("If-None-Match")
+	if err != nil {
+		return true
+	}
+	if any {
+		return false
+	}
+	for _, tag := range tags {
+		if v.hasETag() && tag.WeakMatch(v.ETag) {
+			return false
+		}
+	}
+	return true
+}
+
+// modifiedSince compares at the one second resolution of HTTP-dates.
+func (v Validators) modifiedSince(t time.Time) bool {
+	return v.LastModified.Truncate(time.Second).After(t)
+}
+
+// notModifiedFields are the fields RFC 9110 section 15.4.5 requires a 304 to
+// send when the 200 response would have.
+var notModifiedFields = []string{"Cache-Control", "Content-Location", "Date", "Expires", "Vary"}
+
+func (w *Writer) notModified(v Validators, full headers.Headers) bool {
+	h := headers.Headers{}
+	for _, field := range full {
+		for _, name := range notModifiedFields {
+			if strings.EqualFold(field.Name, name) {
+				h.Add(field.Name, field.Value)
+			}
+		}
+	}
+	v.SetHeaders(&h)
+	err := w.WriteStatusLine(NotModified)
+	if err == nil {
+		w.WriteHeaders(h)
+	}
+	return false
+}
+
+func (w *Writer) preconditionFailed(req *request.Request, field string) bool {
+	w.WriteError(req, PreconditionFailed, Problem{
+		Detail: "The condition in " + field + " does not hold for the current representation.",
+	})
+	return false
+}
@@ -0,0 +1,128 @@
+package response
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/5tuartw/httpfromtcp/internal/headers"
+	"github.com/5tuartw/httpfromtcp/internal/request"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func conditionalRequest(t *testing.T, method string, fields ...string) *request.Request {
+	t.Helper()
+	raw := method + " / HTTP/1.1\r\nHost: localhost\r\n"
+	for _, field := range fields {
+		raw += field + "\r\n"
+	}
+	req, err := request.RequestFromReader(strings.NewReader(raw + "\r\n"))
+	require.NoError(t, err)
+	return req
+}
+
+func TestCheckPreconditions(t *testing.T) {
+	modified := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	v := Validators{ETag: headers.ETag{Tag: "v2"}, LastModified: modified}
+	check := func(req *request.Request) (bool, string) {
+		buf := &bytes.Buffer{}
+		w := &Writer{IoWriter: buf}
+		ok := CheckPreconditions(w, req, v)
+		return ok, buf.String()
+	}
+
+	// Test: no conditions
+	ok, out := check(conditionalRequest(t, "GET"))
+	assert.True(t, ok)
+	assert.Empty(t, out)
+
+	// Test: If-None-Match weak comparison gives 304 with validators
+	ok, out = check(conditionalRequest(t, "GET", `If-None-Match: "v1", W/"v2"`))
+	assert.False(t, ok)
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 304 Not Modified\r\n"), out)
+	assert.Contains(t, out, "ETag: \"v2\"\r\n")
+	assert.Contains(t, out, "Last-Modified: Wed, 01 May 2024 12:00:00 GMT\r\n")
+
+	// Test: If-None-Match on an unsafe method fails with 412
+	ok, out = check(conditionalRequest(t, "PUT", "If-None-Match: *"))
+	assert.False(t, ok)
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 412 Precondition Failed\r\n"), out)
+
+	// Test: If-Match needs a strong match
+	ok, _ = check(conditionalRequest(t, "PUT", `If-Match: "v2"`))
+	assert.True(t, ok)
+	ok, out = check(conditionalRequest(t, "PUT", `If-Match: W/"v2"`))
+	assert.False(t, ok)
+	assert.Contains(t, out, "412 Precondition Failed")
+
+	// Test: If-None-Match takes precedence over If-Modified-Since
+	ok, _ = check(conditionalRequest(t, "GET", `If-None-Match: "v1"`, "If-Modified-Since: Thu, 02 May 2024 00:00:00 GMT"))
+	assert.True(t, ok)
+
+	// Test: dates
+	ok, out = check(conditionalRequest(t, "GET", "If-Modified-Since: Wed, 01 May 2024 12:00:00 GMT"))
+	assert.False(t, ok)
+	assert.Contains(t, out, "304 Not Modified")
+	ok, _ = check(conditionalRequest(t, "GET", "If-Modified-Since: Wed, 01 May 2024 11:59:59 GMT"))
+	assert.True(t, ok)
+	ok, out = check(conditionalRequest(t, "DELETE", "If-Unmodified-Since: Tue, 30 Apr 2024 00:00:00 GMT"))
+	assert.False(t, ok)
+	assert.Contains(t, out, "412 Precondition Failed")
+	ok, _ = check(conditionalRequest(t, "GET", "If-Modified-Since: not a date"))
+	assert.True(t, ok)
+}
+
+func TestIfRange(t *testing.T) {
+	v := Validators{ETag: headers.ETag{Tag: "v2"}, LastModified: time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)}
+
+	assert.True(t, IfRange(conditionalRequest(t, "GET"), v))
+	assert.True(t, IfRange(conditionalRequest(t, "GET", `If-Range: "v2"`), v))
+	assert.False(t, IfRange(conditionalRequest(t, "GET", `If-Range: W/"v2"`), v))
+	assert.True(t, IfRange(conditionalRequest(t, "GET", "If-Range: Wed, 01 May 2024 12:00:00 GMT"), v))
+	assert.False(t, IfRange(conditionalRequest(t, "GET", "If-Range: Tue, 30 Apr 2024 00:00:00 GMT"), v))
+}
+
+func TestWriteConditional(t *testing.T) {
+	body := []byte("hello")
+	etag := StrongETag(body)
+	assert.Len(t, etag.Tag, 32)
+	assert.False(t, etag.Weak)
+
+	// Test: full response carries the generated ETag
+	buf := &bytes.Buffer{}
+	w := &Writer{IoWriter: buf}
+	require.NoError(t, w.WriteConditional(conditionalRequest(t, "GET"), GetDefaultHeaders(0), body))
+	out := buf.String()
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 200 OK\r\n"), out)
+	assert.Contains(t, out, "Content-Length: 5\r\n")
+	assert.Contains(t, out, "ETag: "+etag.String()+"\r\n")
+	assert.True(t, strings.HasSuffix(out, "\r\n\r\nhello"))
+
+	// Test: repeat request is answered without the body
+	buf = &bytes.Buffer{}
+	w = &Writer{IoWriter: buf, KeepAlive: true}
+	require.NoError(t, w.WriteConditional(conditionalRequest(t, "GET", "If-None-Match: "+etag.String()), GetDefaultHeaders(0), body))
+	out = buf.String()
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 304 Not Modified\r\n"), out)
+	assert.NotContains(t, out, "hello")
+	assert.True(t, w.Reusable())
+
+	// Test: the 304 repeats the caching fields of the 200 but not the others
+	h := GetDefaultHeaders(0)
+	h.Set("Cache-Control", "max-age=60")
+	h.Set("Expires", "Thu, 01 Jan 2026 00:00:00 GMT")
+	h.Set("Vary", "Accept-Encoding")
+	h.Set("Content-Location", "/greeting.txt")
+	buf = &bytes.Buffer{}
+	w = &Writer{IoWriter: buf}
+	require.NoError(t, w.WriteConditional(conditionalRequest(t, "GET", "If-None-Match: "+etag.String()), h, body))
+	out = buf.String()
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 304 Not Modified\r\n"), out)
+	assert.Contains(t, out, "Cache-Control: max-age=60\r\n")
+	assert.Contains(t, out, "Expires: Thu, 01 Jan 2026 00:00:00 GMT\r\n")
+	assert.Contains(t, out, "Vary: Accept-Encoding\r\n")
+	assert.Contains(t, out, "Content-Location: /greeting.txt\r\n")
+	assert.NotContains(t, out, "Content-Type")
+}
@@ -0,0 +1,16 @@
+package response
+
+import (
+	"github.com/5tuartw/httpfromtcp/internal/cookie"
+	"github.com/5tuartw/httpfromtcp/internal/headers"
+)
+
+// SetCookie adds c to h as its own Set-Cookie line.
+func SetCookie(h *headers.Headers, c *cookie.Cookie) error {
+	err := c.Validate()
+	if err != nil {
+		return err
+	}
+	h.Add("Set-Cookie", c.String())
+	return nil
+}
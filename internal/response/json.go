@@ -0,0 +1,52 @@
+package response
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/5tuartw/httpfromtcp/internal/request"
+)
+
+// WriteJSON writes a complete response with v encoded as the body.
+func (w *Writer) WriteJSON(s StatusCode, v any) error {
+	return w.writeJSON(s, "application/json", v)
+}
+
+func (w *Writer) writeJSON(s StatusCode, contentType string, v any) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("error encoding JSON response: %v", err)
+	}
+
+	h := GetDefaultHeaders(len(body))
+	h.Set("Content-Type", contentType)
+
+	err = w.WriteStatusLine(s)
+	if err != nil {
+		return err
+	}
+	err = w.WriteHeaders(h)
+	if err != nil {
+		return err
+	}
+	_, err = w.WriteBody(body)
+	return err
+}
+
+// BindJSON decodes the request body into v. If that fails it answers with a
+// 400, 413 or 415 problem response and returns false, so handlers can simply
+// return.
+func BindJSON(w *Writer, req *request.Request, v any, opts request.JSONOptions) bool {
+	err := req.DecodeJSON(v, opts)
+	if err == nil {
+		return true
+	}
+
+	status := BadRequest
+	detail := err.Error()
+	if jsonErr, ok := err.(*request.JSONError); ok {
+		status = StatusCode(jsonErr.Status)
+	}
+	w.WriteProblem(status, Problem{Detail: detail})
+	return false
+}
@@ -0,0 +1,144 @@
+package response
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"html"
+	"sort"
+	"strings"
+
+	"github.com/5tuartw/httpfromtcp/internal/headers"
+	"github.com/5tuartw/httpfromtcp/internal/negotiate"
+	"github.com/5tuartw/httpfromtcp/internal/request"
+)
+
+const (
+	problemJSON = "application/problem+json"
+	problemHTML = "text/html"
+	problemText = "text/plain"
+)
+
+// Problem is an RFC 9457 problem details object. Extensions are added as
+// extra top-level members; they cannot replace the standard ones.
+type Problem struct {
+	Type       string         `json:"type,omitempty"`
+	Title      string         `json:"title,omitempty"`
+	Status     int            `json:"status,omitempty"`
+	Detail     string         `json:"detail,omitempty"`
+	Instance   string         `json:"instance,omitempty"`
+	Extensions map[string]any `json:"-"`
+}
+
+func (p Problem) MarshalJSON() ([]byte, error) {
+	type standard Problem
+	body, err := json.Marshal(standard(p))
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(p.Extensions))
+	for name := range p.Extensions {
+		switch name {
+		case "type", "title", "status", "detail", "instance":
+			continue
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	buf := bytes.NewBuffer(body[:len(body)-1])
+	for _, name := range names {
+		key, err := json.Marshal(name)
+		if err != nil {
+			return nil, err
+		}
+		value, err := json.Marshal(p.Extensions[name])
+		if err != nil {
+			return nil, err
+		}
+		if buf.Len() > 1 {
+			buf.WriteByte(',')
+		}
+		buf.Write(key)
+		buf.WriteByte(':')
+		buf.Write(value)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// WriteProblem writes p as an application/problem+json response, taking the
+// status and a default title from s.
+func (w *Writer) WriteProblem(s StatusCode, p Problem) error {
+	return w.writeProblem(s, p, problemJSON, nil)
+}
+
+// WriteError writes an error response for s, as problem JSON, HTML or plain
+// text depending on what the request accepts. req may be nil when the request
+// could not be parsed, in which case JSON is used.
+func (w *Writer) WriteError(req *request.Request, s StatusCode, p Problem) error {
+	if req == nil {
+		return w.writeProblem(s, p, problemJSON, nil)
+	}
+	h := headers.Headers{}
+	mediaType, err := negotiate.MediaType(req.Headers, &h, problemJSON, "application/json", problemText, problemHTML)
+	if err != nil || mediaType == "application/json" {
+		// Clients that accept nothing we offer still get the error
+		mediaType = problemJSON
+	}
+	return w.writeProblem(s, p, mediaType, h)
+}
+
+// writeProblem renders p as mediaType, adding extra to the default headers.
+func (w *Writer) writeProblem(s StatusCode, p Problem, mediaType string, extra headers.Headers) error {
+	p.Status = int(s)
+	if p.Title == "" {
+		p.Title = s.Reason()
+	}
+
+	var body []byte
+	contentType := mediaType
+	switch mediaType {
+	case problemJSON:
+		var err error
+		body, err = json.Marshal(p)
+		if err != nil {
+			return fmt.Errorf("error encoding problem details: %v", err)
+		}
+	case problemHTML:
+		body = []byte(problemPage(s, p))
+		contentType += "; charset=utf-8"
+	default:
+		body = []byte(s.String() + "\n\n" + p.Title + "\n" + p.Detail + "\n")
+		contentType += "; charset=utf-8"
+	}
+
+	h := GetDefaultHeaders(len(body))
+	h.Set("Content-Type", contentType)
+	for _, field := range extra {
+		h.Add(field.Name, field.Value)
+	}
+
+	err := w.WriteStatusLine(s)
+	if err != nil {
+		return err
+	}
+	err = w.WriteHeaders(h)
+	if err != nil {
+		return err
+	}
+	_, err = w.WriteBody(body)
+	return err
+}
+
+func problemPage(s StatusCode, p Problem) string {
+	var sb strings.Builder
+	sb.WriteString("<html>\n<head>\n<title>" + html.EscapeString(s.String()) + "</title>\n</head>\n<body>\n")
+	sb.WriteString("<h1>" + html.EscapeString(p.Title) + "</h1>\n")
+	if p.Detail != "" {
+		sb.WriteString("<p>" + html.EscapeString(p.Detail) + "</p>\n")
+	}
+	sb.WriteString("</body>\n</html>\n")
+	return sb.String()
+}
@@ -2,29 +2,14 @@ package response
 
 import (
 	"fmt"
+	"hash"
 	"io"
-	"net/http"
 	"strconv"
+	"strings"
 
 	"github.com/5tuartw/httpfromtcp/internal/headers"
 )
 
-type StatusCode int
-
-const (
-	OK                  StatusCode = 200
-	BadRequest          StatusCode = 400
-	InternalServerError StatusCode = 500
-)
-
-func (s StatusCode) String() string {
-	reason := http.StatusText(int(s))
-	if reason == "" {
-		return fmt.Sprintf("%d", int(s))
-	}
-	return fmt.Sprintf("%d %s", int(s), reason)
-}
-
 type WriterState int
 
 const (
@@ -57,42 +42,106 @@ type Writer struct {
 	State       WriterState
 	IoWriter    io.Writer
 	HasTrailers bool
+	// RequestVersion is the HTTP version of the request being answered.
+	// For "1.0" chunked bodies are sent unframed and delimited by close.
+	RequestVersion string
+	// KeepAlive is whether the connection may be reused after this response.
+	// WriteHeaders clears it when the response cannot be delimited or asks
+	// for the connection to be closed.
+	KeepAlive bool
+	// DiscardBody is set for HEAD requests. Body, chunks and trailers are
+	// accepted and counted but not sent, so the handler's Content-Length
+	// still describes the GET response.
+	DiscardBody bool
+
+	header         headers.Headers
+	checksums      []ChecksumAlgorithm
+	hashes         []hash.Hash
+	bodyLength     int
+	statusCode     StatusCode
+	closeDelimited bool
+	contentLength  int
+	bodyWritten    int
 }
 
 const crlf = "\r\n"
 
 func GetDefaultHeaders(contentLen int) headers.Headers {
 	defaultHeaders := headers.Headers{}
-	defaultHeaders["Content-Length"] = strconv.Itoa(contentLen)
-	defaultHeaders["Connection"] = "close"
-	defaultHeaders["Content-Type"] = "text/plain"
+	defaultHeaders.Set("Content-Length", strconv.Itoa(contentLen))
+	defaultHeaders.Set("Content-Type", "text/plain")
 	return defaultHeaders
 }
 
+// Header returns fields to be sent with the final response, for middleware
+// that runs before the handler. WriteHeaders adds those whose name the
+// handler's headers do not already use, and merges Vary.
+func (w *Writer) Header() *headers.Headers {
+	return &w.header
+}
+
 func (w *Writer) WriteStatusLine(s StatusCode) error {
+	return w.WriteStatusLineReason(s, s.Reason())
+}
+
+// WriteStatusLineReason writes the status line with a custom reason phrase,
+// for example to forward one received from an upstream server.
+func (w *Writer) WriteStatusLineReason(s StatusCode, reason string) error {
 	if w.State != WritingInitialised {
 		return fmt.Errorf("cannot write status while writer state is %s", w.State)
 	}
-	_, err := w.IoWriter.Write([]byte("HTTP/1.1 " + s.String() + crlf))
+	if !s.Valid() {
+		return fmt.Errorf("invalid status code: %d", int(s))
+	}
+	if !validReason(reason) {
+		return fmt.Errorf("invalid reason phrase: %q", reason)
+	}
+	_, err := w.IoWriter.Write([]byte(statusLine(s, reason)))
 	if err != nil {
 		return err
 	}
+	w.statusCode = s
 	w.State = WritingStatusDone
 	return nil
 }
 
+// WriteInformational writes a 1xx interim response, such as 100 Continue or
+// 103 Early Hints. Any number may be sent before the final status line.
+// HTTP/1.0 clients do not understand them, so for those nothing is sent.
+func (w *Writer) WriteInformational(s StatusCode, h headers.Headers) error {
+	if w.State != WritingInitialised {
+		return fmt.Errorf("cannot write interim response while writer state is %s", w.State)
+	}
+	if !s.IsInformational() || s == SwitchingProtocols {
+		return fmt.Errorf("%d is not an interim status code", int(s))
+	}
+	if w.RequestVersion == "1.0" {
+		return nil
+	}
+	_, err := w.IoWriter.Write([]byte(statusLine(s, s.Reason())))
+	if err != nil {
+		return err
+	}
+	err = w.writeFieldLines(h)
+	if err != nil {
+		return err
+	}
+	_, err = w.IoWriter.Write([]byte(crlf))
+	return err
+}
+
 func (w *Writer) WriteHeaders(headers headers.Headers) error {
 	if w.State != WritingStatusDone {
 		return fmt.Errorf("cannot write headers while writer state is %s", w.State)
 	}
 
-	for key, value := range headers {
-		_, err := w.IoWriter.Write([]byte(key + ": " + value + crlf))
-		if err != nil {
-			return err
-		}
+	headers = w.prepareHeaders(headers)
+
+	err := w.writeFieldLines(headers)
+	if err != nil {
+		return err
 	}
-	_, err := w.IoWriter.Write([]byte(crlf))
+	_, err = w.IoWriter.Write([]byte(crlf))
 	if err != nil {
 		return err
 	}
@@ -104,14 +153,23 @@ func (w *Writer) WriteBody(p []byte) (int, error) {
 	if w.State != WritingHeadersDone && w.State != WritingBody {
 		return 0, fmt.Errorf("cannot write body while writer state is %s", w.State)
 	}
-	n, err := w.IoWriter.Write(p)
+	n, err := w.write(p)
 	if err != nil {
 		return 0, err
 	}
+	w.bodyWritten += n
 	//w.State = WritingComplete
 	return n, nil
 }
 
+// write sends body bytes, including chunk framing, unless they are discarded.
+func (w *Writer) write(p []byte) (int, error) {
+	if w.DiscardBody {
+		return len(p), nil
+	}
+	return w.IoWriter.Write(p)
+}
+
 func (w *Writer) WriteChunkedBody(p []byte) (int, error) {
 	if w.State != WritingHeadersDone && w.State != WritingBody {
 		return 0, fmt.Errorf("cannot write chunked body in current state: %s", w.State)
@@ -125,16 +183,26 @@ func (w *Writer) WriteChunkedBody(p []byte) (int, error) {
 		return 0, nil
 	}
 
+	for _, h := range w.hashes {
+		h.Write(p)
+	}
+	w.bodyLength += dataLength
+
+	if w.closeDelimited {
+		return w.WriteBody(p)
+	}
+
 	hexLengthString := fmt.Sprintf("%x", dataLength)
-	_, err := w.WriteBody([]byte(hexLengthString + "\r\n"))
+	_, err := w.write([]byte(hexLengthString + "\r\n"))
 	if err != nil {
 		return 0, fmt.Errorf("error writing chunk size to body: %v", err)
 	}
 	all := append(p, []byte("\r\n")...)
-	_, err = w.WriteBody(all)
+	_, err = w.write(all)
 	if err != nil {
 		return 0, fmt.Errorf("error writing data chunk to body: %v", err)
 	}
+	w.bodyWritten += dataLength
 	return dataLength, nil
 }
 
@@ -142,24 +210,42 @@ func (w *Writer) WriteChunkedBodyDone() (int, error) {
 	if w.State != WritingBody {
 		return 0, fmt.Errorf("cannot write chunked body done in state %s", w.State)
 	}
-	var bytesWritten int
-	var err error
-	if w.HasTrailers {
-		// Just write "0\r\n" when trailers will follow
-		bytesWritten, err = fmt.Fprint(w.IoWriter, "0\r\n")
-		if err != nil {
-			return bytesWritten, err
+	if w.closeDelimited || w.DiscardBody {
+		// The body simply ends when the connection is closed, or was never sent
+		if w.HasTrailers {
+			w.State = WritingBodyDone
+		} else {
+			w.State = WritingComplete
 		}
-		w.State = WritingBodyDone
-	} else {
-		// Write "0\r\n\r\n" when no trailers
-		bytesWritten, err = fmt.Fprint(w.IoWriter, "0\r\n\r\n")
+		return 0, nil
+	}
+
+	bytesWritten, err := fmt.Fprint(w.IoWriter, "0\r\n")
+	if err != nil {
+		return bytesWritten, err
+	}
+
+	if len(w.checksums) > 0 {
+		n, err := w.writeChecksumTrailers()
+		bytesWritten += n
 		if err != nil {
 			return bytesWritten, err
 		}
-		w.State = WritingComplete
 	}
 
+	if w.HasTrailers {
+		// Caller-supplied trailers follow via WriteTrailers
+		w.State = WritingBodyDone
+		return bytesWritten, nil
+	}
+
+	n, err := fmt.Fprint(w.IoWriter, crlf)
+	bytesWritten += n
+	if err != nil {
+		return bytesWritten, err
+	}
+	w.State = WritingComplete
+
 	return bytesWritten, nil
 }
 
@@ -170,17 +256,132 @@ func (w *Writer) WriteTrailers(h headers.Headers) error {
 	if w.State != WritingBodyDone {
 		return fmt.Errorf("cannot write trailers in state %s", w.State)
 	}
+	if w.closeDelimited || w.DiscardBody {
+		// Trailers can only be sent with chunked encoding
+		w.State = WritingComplete
+		return nil
+	}
 
-	for key, value := range h {
-		_, err := w.IoWriter.Write([]byte(key + ": " + value + crlf))
-		if err != nil {
-			return err
-		}
+	err := w.writeFieldLines(h)
+	if err != nil {
+		return err
 	}
-	_, err := w.IoWriter.Write([]byte(crlf))
+	_, err = w.IoWriter.Write([]byte(crlf))
 	if err != nil {
 		return err
 	}
 	w.State = WritingComplete
 	return nil
 }
+
+// writeFieldLines writes h after validating all of it, so that a bad field
+// never leaves a partial header section on the wire.
+func (w *Writer) writeFieldLines(h headers.Headers) error {
+	err := h.Validate()
+	if err != nil {
+		return err
+	}
+	for _, field := range h {
+		_, err := w.IoWriter.Write([]byte(field.Name + ": " + field.Value + crlf))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Status is the final status code written, or 0 before the status line.
+func (w *Writer) Status() StatusCode {
+	return w.statusCode
+}
+
+// BytesWritten is the number of body bytes sent, not counting chunk framing
+// or discarded HEAD bodies.
+func (w *Writer) BytesWritten() int {
+	if w.DiscardBody {
+		return 0
+	}
+	return w.bodyWritten
+}
+
+// Reusable reports whether the connection can carry another response: the
+// client and response allow keep-alive and the body was completely written.
+func (w *Writer) Reusable() bool {
+	if !w.KeepAlive {
+		return false
+	}
+	if w.State == WritingComplete {
+		return true
+	}
+	if w.DiscardBody && w.State >= WritingHeadersDone {
+		return true
+	}
+	if w.State == WritingHeadersDone || w.State == WritingBody {
+		return w.contentLength >= 0 && w.bodyWritten == w.contentLength
+	}
+	return false
+}
+
+// statusLine always has the space after the code, even with no reason.
+func statusLine(s StatusCode, reason string) string {
+	return "HTTP/1.1 " + strconv.Itoa(int(s)) + " " + reason + crlf
+}
+
+// prepareHeaders applies the framing and connection rules for this response
+// to a copy of the handler's headers.
+func (w *Writer) prepareHeaders(h headers.Headers) headers.Headers {
+	handlerHeaders := h
+	h = h.Clone()
+	for _, field := range w.header {
+		if strings.EqualFold(field.Name, "Vary") {
+			for _, name := range strings.Split(field.Value, ",") {
+				h.AddVary(strings.TrimSpace(name))
+			}
+		} else if !handlerHeaders.Has(field.Name) {
+			h.Add(field.Name, field.Value)
+		}
+	}
+
+	w.contentLength = -1
+	if length, err := h.ContentLength(); err == nil && length >= 0 {
+		w.contentLength = int(length)
+	}
+	if w.statusCode.IsInformational() || w.statusCode == NoContent || w.statusCode == NotModified {
+		w.contentLength = 0
+	}
+
+	chunked := hasToken(h.Get("Transfer-Encoding"), "chunked")
+	if chunked && w.RequestVersion == "1.0" {
+		h.Del("Transfer-Encoding")
+		h.Del("Trailer")
+		w.closeDelimited = true
+		chunked = false
+	}
+	if chunked && len(w.checksums) > 0 {
+		h = w.withTrailerDeclaration(h)
+	}
+
+	if w.contentLength < 0 && !chunked && !w.DiscardBody {
+		w.KeepAlive = false
+	}
+	connection := h.Get("Connection")
+	if hasToken(connection, "close") {
+		w.KeepAlive = false
+	}
+	if !w.KeepAlive {
+		h.Set("Connection", "close")
+	} else if w.RequestVersion == "1.0" && !hasToken(connection, "keep-alive") {
+		h.Set("Connection", "keep-alive")
+	}
+
+	return h
+}
+
+func hasToken(value, token string) bool {
+	for _, part := range strings.Split(value, ",") {
+		if strings.EqualFold(strings.TrimSpace(part), token) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,298 @@
+package response
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/5tuartw/httpfromtcp/internal/cookie"
+	"github.com/5tuartw/httpfromtcp/internal/headers"
+	"github.com/5tuartw/httpfromtcp/internal/request"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestChecksumTrailers(t *testing.T) {
+	// Test: SHA256 digest and length trailers after chunked body
+	buf := &bytes.Buffer{}
+	w := &Writer{IoWriter: buf}
+	require.NoError(t, w.WriteStatusLine(OK))
+	require.NoError(t, w.UseChecksumTrailers(ChecksumSHA256))
+	require.NoError(t, w.WriteHeaders(headers.Headers{{Name: "Transfer-Encoding", Value: "chunked"}}))
+	_, err := w.WriteChunkedBody([]byte("hello "))
+	require.NoError(t, err)
+	_, err = w.WriteChunkedBody([]byte("world"))
+	require.NoError(t, err)
+	_, err = w.WriteChunkedBodyDone()
+	require.NoError(t, err)
+	assert.Equal(t, WritingComplete, w.State)
+
+	sum := sha256.Sum256([]byte("hello world"))
+	out := buf.String()
+	assert.Contains(t, out, "Trailer: X-Content-SHA256, X-Content-Length\r\n")
+	assert.True(t, strings.HasSuffix(out, "0\r\nX-Content-SHA256: "+hex.EncodeToString(sum[:])+"\r\nX-Content-Length: 11\r\n\r\n"))
+
+	// Test: multiple algorithms merged with declared trailers
+	buf = &bytes.Buffer{}
+	w = &Writer{IoWriter: buf, HasTrailers: true}
+	require.NoError(t, w.WriteStatusLine(OK))
+	require.NoError(t, w.UseChecksumTrailers(ChecksumCRC32C, ChecksumMD5))
+	require.NoError(t, w.WriteHeaders(headers.Headers{{Name: "Transfer-Encoding", Value: "chunked"}, {Name: "trailer", Value: "X-Note"}}))
+	_, err = w.WriteChunkedBody([]byte("abc"))
+	require.NoError(t, err)
+	_, err = w.WriteChunkedBodyDone()
+	require.NoError(t, err)
+	assert.Equal(t, WritingBodyDone, w.State)
+	require.NoError(t, w.WriteTrailers(headers.Headers{{Name: "X-Note", Value: "done"}}))
+
+	md5Sum := md5.Sum([]byte("abc"))
+	out = buf.String()
+	assert.Contains(t, out, "Trailer: X-Note, X-Content-CRC32C, X-Content-MD5, X-Content-Length\r\n")
+	assert.Contains(t, out, "X-Content-CRC32C: 364b3fb7\r\n")
+	assert.Contains(t, out, "X-Content-MD5: "+hex.EncodeToString(md5Sum[:])+"\r\n")
+	assert.True(t, strings.HasSuffix(out, "X-Content-Length: 3\r\nX-Note: done\r\n\r\n"))
+
+	// Test: enabling after headers are written
+	w = &Writer{IoWriter: &bytes.Buffer{}}
+	require.NoError(t, w.WriteStatusLine(OK))
+	require.NoError(t, w.WriteHeaders(headers.Headers{}))
+	require.Error(t, w.UseChecksumTrailers(ChecksumSHA256))
+}
+
+func TestWriteInformational(t *testing.T) {
+	// Test: interim responses before the final status line
+	buf := &bytes.Buffer{}
+	w := &Writer{IoWriter: buf}
+	require.NoError(t, w.WriteInformational(Continue, nil))
+	require.NoError(t, w.WriteInformational(EarlyHints, headers.Headers{{Name: "Link", Value: "</style.css>; rel=preload"}}))
+	require.NoError(t, w.WriteStatusLine(OK))
+	assert.Equal(t, "HTTP/1.1 100 Continue\r\n\r\n"+
+		"HTTP/1.1 103 Early Hints\r\nLink: </style.css>; rel=preload\r\n\r\n"+
+		"HTTP/1.1 200 OK\r\n", buf.String())
+
+	// Test: non-1xx and after the final status line
+	require.Error(t, w.WriteInformational(Continue, nil))
+	w = &Writer{IoWriter: &bytes.Buffer{}}
+	require.Error(t, w.WriteInformational(OK, nil))
+
+	// Test: 101 is a final response to an upgrade, not an interim one
+	require.Error(t, w.WriteInformational(SwitchingProtocols, nil))
+
+	// Test: nothing is sent to HTTP/1.0 clients
+	buf = &bytes.Buffer{}
+	w = &Writer{IoWriter: buf, RequestVersion: "1.0"}
+	require.NoError(t, w.WriteInformational(EarlyHints, nil))
+	require.NoError(t, w.WriteStatusLine(OK))
+	assert.Equal(t, "HTTP/1.1 200 OK\r\n", buf.String())
+}
+
+func TestConnectionManagement(t *testing.T) {
+	// Test: HTTP/1.0 falls back to a close-delimited body
+	buf := &bytes.Buffer{}
+	w := &Writer{IoWriter: buf, RequestVersion: "1.0", KeepAlive: true}
+	require.NoError(t, w.WriteStatusLine(OK))
+	require.NoError(t, w.UseChecksumTrailers(ChecksumSHA256))
+	require.NoError(t, w.WriteHeaders(headers.Headers{{Name: "Transfer-Encoding", Value: "chunked"}}))
+	_, err := w.WriteChunkedBody([]byte("hello"))
+	require.NoError(t, err)
+	_, err = w.WriteChunkedBodyDone()
+	require.NoError(t, err)
+	assert.Equal(t, "HTTP/1.1 200 OK\r\nConnection: close\r\n\r\nhello", buf.String())
+	assert.False(t, w.Reusable())
+
+	// Test: HTTP/1.0 keep-alive with a known length
+	buf = &bytes.Buffer{}
+	w = &Writer{IoWriter: buf, RequestVersion: "1.0", KeepAlive: true}
+	require.NoError(t, w.WriteStatusLine(OK))
+	require.NoError(t, w.WriteHeaders(headers.Headers{{Name: "Content-Length", Value: "2"}}))
+	assert.False(t, w.Reusable())
+	_, err = w.WriteBody([]byte("hi"))
+	require.NoError(t, err)
+	assert.Contains(t, buf.String(), "Connection: keep-alive\r\n")
+	assert.True(t, w.Reusable())
+
+	// Test: handler asks for close
+	buf = &bytes.Buffer{}
+	w = &Writer{IoWriter: buf, RequestVersion: "1.1", KeepAlive: true}
+	require.NoError(t, w.WriteStatusLine(OK))
+	require.NoError(t, w.WriteHeaders(headers.Headers{{Name: "Content-Length", Value: "0"}, {Name: "connection", Value: "close"}}))
+	assert.False(t, w.Reusable())
+
+	// Test: HTTP/1.1 chunked keeps the connection
+	buf = &bytes.Buffer{}
+	w = &Writer{IoWriter: buf, RequestVersion: "1.1", KeepAlive: true}
+	require.NoError(t, w.WriteStatusLine(OK))
+	require.NoError(t, w.WriteHeaders(headers.Headers{{Name: "Transfer-Encoding", Value: "chunked"}}))
+	_, err = w.WriteChunkedBody([]byte("hi"))
+	require.NoError(t, err)
+	_, err = w.WriteChunkedBodyDone()
+	require.NoError(t, err)
+	assert.NotContains(t, buf.String(), "Connection")
+	assert.True(t, w.Reusable())
+}
+
+func TestWriteHeadersOrder(t *testing.T) {
+	// Test: fields are written in the order given, repeats on separate lines
+	buf := &bytes.Buffer{}
+	w := &Writer{IoWriter: buf, KeepAlive: true}
+	h := GetDefaultHeaders(0)
+	h.Add("Set-Cookie", "a=1")
+	h.Add("Set-Cookie", "b=2")
+	h.Set("Content-Type", "text/html")
+	require.NoError(t, w.WriteStatusLine(OK))
+	require.NoError(t, w.WriteHeaders(h))
+	assert.Equal(t, "HTTP/1.1 200 OK\r\n"+
+		"Content-Length: 0\r\n"+
+		"Content-Type: text/html\r\n"+
+		"Set-Cookie: a=1\r\n"+
+		"Set-Cookie: b=2\r\n"+
+		"\r\n", buf.String())
+}
+
+func TestWriteHeadersValidation(t *testing.T) {
+	// Test: injected CRLF is rejected before anything is written
+	buf := &bytes.Buffer{}
+	w := &Writer{IoWriter: buf}
+	require.NoError(t, w.WriteStatusLine(OK))
+	h := GetDefaultHeaders(0)
+	h.Set("X-Echo", "value\r\nSet-Cookie: session=stolen")
+	err := w.WriteHeaders(h)
+	require.ErrorIs(t, err, headers.ErrInvalidFieldValue)
+	assert.Equal(t, "HTTP/1.1 200 OK\r\n", buf.String())
+	assert.Equal(t, WritingStatusDone, w.State)
+
+	// Test: invalid trailer name
+	w = &Writer{IoWriter: &bytes.Buffer{}, HasTrailers: true}
+	require.NoError(t, w.WriteStatusLine(OK))
+	require.NoError(t, w.WriteHeaders(headers.Headers{{Name: "Transfer-Encoding", Value: "chunked"}}))
+	_, err = w.WriteChunkedBody([]byte("hi"))
+	require.NoError(t, err)
+	_, err = w.WriteChunkedBodyDone()
+	require.NoError(t, err)
+	require.ErrorIs(t, w.WriteTrailers(headers.Headers{{Name: "Bad Name", Value: "x"}}), headers.ErrInvalidFieldName)
+}
+
+func TestSetCookie(t *testing.T) {
+	// Test: each cookie is written on its own line
+	buf := &bytes.Buffer{}
+	w := &Writer{IoWriter: buf, KeepAlive: true}
+	h := GetDefaultHeaders(0)
+	require.NoError(t, SetCookie(&h, &cookie.Cookie{Name: "a", Value: "1", HttpOnly: true}))
+	require.NoError(t, SetCookie(&h, &cookie.Cookie{Name: "b", Value: "2", Path: "/"}))
+	require.Error(t, SetCookie(&h, &cookie.Cookie{Name: "c", Value: "a;b"}))
+	require.NoError(t, w.WriteStatusLine(OK))
+	require.NoError(t, w.WriteHeaders(h))
+	assert.Contains(t, buf.String(), "Set-Cookie: a=1; HttpOnly\r\nSet-Cookie: b=2; Path=/\r\n\r\n")
+}
+
+func TestWriteJSON(t *testing.T) {
+	// Test: complete JSON response
+	buf := &bytes.Buffer{}
+	w := &Writer{IoWriter: buf, KeepAlive: true}
+	require.NoError(t, w.WriteJSON(OK, map[string]int{"count": 3}))
+	assert.Equal(t, "HTTP/1.1 200 OK\r\n"+
+		"Content-Length: 11\r\n"+
+		"Content-Type: application/json\r\n"+
+		"\r\n"+
+		`{"count":3}`, buf.String())
+	assert.True(t, w.Reusable())
+
+	// Test: BindJSON answers a bad request with problem details
+	req, err := request.RequestFromReader(strings.NewReader("POST / HTTP/1.1\r\nHost: localhost\r\nContent-Type: text/plain\r\nContent-Length: 2\r\n\r\n{}"))
+	require.NoError(t, err)
+	buf = &bytes.Buffer{}
+	w = &Writer{IoWriter: buf}
+	var v map[string]any
+	assert.False(t, BindJSON(w, req, &v, request.JSONOptions{}))
+	out := buf.String()
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 415 Unsupported Media Type\r\n"), out)
+	assert.Contains(t, out, "Content-Type: application/problem+json\r\n")
+	assert.True(t, strings.HasSuffix(out, `{"title":"Unsupported Media Type","status":415,"detail":"Content-Type must be application/json, got \"text/plain\""}`), out)
+}
+
+func TestWriteError(t *testing.T) {
+	// Test: extensions follow the standard members and cannot replace them
+	body, err := json.Marshal(Problem{
+		Type:       "https://example.com/probs/out-of-credit",
+		Status:     403,
+		Extensions: map[string]any{"balance": 30, "accounts": []string{"a"}, "status": 200},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, `{"type":"https://example.com/probs/out-of-credit","status":403,"accounts":["a"],"balance":30}`, string(body))
+
+	// Test: no request falls back to problem JSON
+	buf := &bytes.Buffer{}
+	w := &Writer{IoWriter: buf}
+	require.NoError(t, w.WriteError(nil, RequestTimeout, Problem{Detail: "too slow"}))
+	out := buf.String()
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 408 Request Timeout\r\n"), out)
+	assert.Contains(t, out, "Content-Type: application/problem+json\r\n")
+	assert.NotContains(t, out, "Vary")
+	assert.True(t, strings.HasSuffix(out, `{"title":"Request Timeout","status":408,"detail":"too slow"}`), out)
+
+	// Test: browsers get an escaped HTML page
+	req, err := request.RequestFromReader(strings.NewReader("GET / HTTP/1.1\r\nHost: localhost\r\nAccept: text/html,application/xhtml+xml,*/*;q=0.8\r\n\r\n"))
+	require.NoError(t, err)
+	buf = &bytes.Buffer{}
+	w = &Writer{IoWriter: buf}
+	require.NoError(t, w.WriteError(req, BadRequest, Problem{Detail: "<script>"}))
+	out = buf.String()
+	assert.Contains(t, out, "Content-Type: text/html; charset=utf-8\r\n")
+	assert.Contains(t, out, "Vary: Accept\r\n")
+	assert.Contains(t, out, "<title>400 Bad Request</title>")
+	assert.Contains(t, out, "<p>&lt;script&gt;</p>")
+
+	// Test: plain text when only text is accepted
+	req, err = request.RequestFromReader(strings.NewReader("GET / HTTP/1.1\r\nHost: localhost\r\nAccept: application/json;q=0, text/*\r\n\r\n"))
+	require.NoError(t, err)
+	buf = &bytes.Buffer{}
+	w = &Writer{IoWriter: buf}
+	require.NoError(t, w.WriteError(req, NotFound, Problem{}))
+	out = buf.String()
+	assert.Contains(t, out, "Content-Type: text/plain; charset=utf-8\r\n")
+	assert.True(t, strings.HasSuffix(out, "\r\n\r\n404 Not Found\n\nNot Found\n\n"), out)
+}
+
+func TestDiscardBody(t *testing.T) {
+	// Test: HEAD keeps the GET headers but sends no body
+	buf := &bytes.Buffer{}
+	w := &Writer{IoWriter: buf, KeepAlive: true, DiscardBody: true}
+	require.NoError(t, w.WriteStatusLine(OK))
+	require.NoError(t, w.WriteHeaders(GetDefaultHeaders(5)))
+	n, err := w.WriteBody([]byte("hello"))
+	require.NoError(t, err)
+	assert.Equal(t, 5, n)
+	assert.Equal(t, "HTTP/1.1 200 OK\r\nContent-Length: 5\r\nContent-Type: text/plain\r\n\r\n", buf.String())
+	assert.True(t, w.Reusable())
+
+	// Test: chunked body, terminator and checksum trailers are all dropped
+	buf = &bytes.Buffer{}
+	w = &Writer{IoWriter: buf, KeepAlive: true, DiscardBody: true}
+	require.NoError(t, w.UseChecksumTrailers(ChecksumSHA256))
+	h := headers.Headers{}
+	h.Set("Transfer-Encoding", "chunked")
+	require.NoError(t, w.WriteStatusLine(OK))
+	require.NoError(t, w.WriteHeaders(h))
+	_, err = w.WriteChunkedBody([]byte("hello"))
+	require.NoError(t, err)
+	_, err = w.WriteChunkedBodyDone()
+	require.NoError(t, err)
+	assert.True(t, strings.HasSuffix(buf.String(), "\r\n\r\n"), buf.String())
+	assert.NotContains(t, buf.String(), "hello")
+	assert.True(t, w.Reusable())
+}
+
+func TestPendingHeaders(t *testing.T) {
+	// Test: middleware fields are added unless the handler sets the same name
+	buf := &bytes.Buffer{}
+	w := &Writer{IoWriter: buf, KeepAlive: true}
+	w.Header().Add("X-Request-Id", "abc")
+	w.Header().Add("Content-Type", "application/json")
+	require.NoError(t, w.WriteStatusLine(OK))
+	require.NoError(t, w.WriteHeaders(GetDefaultHeaders(0)))
+	assert.Equal(t, "HTTP/1.1 200 OK\r\nContent-Length: 0\r\nContent-Type: text/plain\r\nX-Request-Id: abc\r\n\r\n", buf.String())
+}
@@ -0,0 +1,52 @@
+package response
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStatusCode(t *testing.T) {
+	// Test: registered and unregistered codes
+	assert.Equal(t, "422 Unprocessable Content", UnprocessableContent.String())
+	assert.Equal(t, "Content Too Large", ContentTooLarge.Reason())
+	assert.Equal(t, "299", StatusCode(299).String())
+	assert.Equal(t, "", StatusCode(299).Reason())
+
+	// Test: validity and classes
+	assert.True(t, StatusCode(599).Valid())
+	assert.False(t, StatusCode(99).Valid())
+	assert.False(t, StatusCode(600).Valid())
+	assert.True(t, EarlyHints.IsInformational())
+	assert.True(t, NoContent.IsSuccess())
+	assert.True(t, PermanentRedirect.IsRedirect())
+	assert.True(t, TooManyRequests.IsClientError())
+	assert.True(t, BadGateway.IsServerError())
+	assert.False(t, BadGateway.IsClientError())
+	assert.Equal(t, "3xx", NotModified.Class())
+	assert.Equal(t, "", StatusCode(1000).Class())
+}
+
+func TestWriteStatusLineReason(t *testing.T) {
+	// Test: custom reason phrase is written as given
+	buf := &bytes.Buffer{}
+	w := &Writer{IoWriter: buf}
+	require.NoError(t, w.WriteStatusLineReason(OK, "Everything Is Fine"))
+	assert.Equal(t, "HTTP/1.1 200 Everything Is Fine\r\n", buf.String())
+
+	// Test: unregistered code keeps the space before the empty reason
+	buf = &bytes.Buffer{}
+	w = &Writer{IoWriter: buf}
+	require.NoError(t, w.WriteStatusLine(StatusCode(299)))
+	assert.Equal(t, "HTTP/1.1 299 \r\n", buf.String())
+
+	// Test: invalid codes and phrases are rejected before writing
+	buf = &bytes.Buffer{}
+	w = &Writer{IoWriter: buf}
+	assert.Error(t, w.WriteStatusLine(StatusCode(1000)))
+	assert.Error(t, w.WriteStatusLineReason(OK, "OK\r\nSet-Cookie: a=b"))
+	assert.Empty(t, buf.String())
+	assert.Equal(t, WritingInitialised, w.State)
+}
@@ -0,0 +1,148 @@
+package server
+
+import (
+	"net"
+	"strconv"
+	"sync"
+
+	"github.com/5tuartw/httpfromtcp/internal/response"
+)
+
+// overloadRetryAfter is the Retry-After, in seconds, sent with 503 responses
+// to connections over a limit.
+const overloadRetryAfter = 5
+
+// OverloadMode is what the server does with connections over MaxConnections.
+type OverloadMode int
+
+const (
+	// OverloadBlock stops accepting until a connection closes, leaving new
+	// connections in the listen backlog.
+	OverloadBlock OverloadMode = iota
+	// OverloadReject accepts the connection, answers 503 Service
+	// Unavailable with Retry-After and closes it.
+	OverloadReject
+)
+
+// connLimits tracks open connections against the configured caps.
+type connLimits struct {
+	slots chan struct{}
+
+	mu    sync.Mutex
+	perIP map[string]int
+}
+
+// WithMaxConnections caps the number of connections open at once. Zero means
+// no limit.
+func WithMaxConnections(n int, mode OverloadMode) Option {
+	return func(s *Server) {
+		s.MaxConnections = n
+		s.OverloadMode = mode
+	}
+}
+
+// WithMaxConnectionsPerIP caps the connections open at once from one client
+// IP address. Connections over it are answered 503 whatever the
+// OverloadMode, since blocking would stall every other client too.
+func WithMaxConnectionsPerIP(n int) Option {
+	return func(s *Server) {
+		s.MaxConnectionsPerIP = n
+	}
+}
+
+func (s *Server) initLimits() {
+	s.limits.perIP = map[string]int{}
+	if s.MaxConnections > 0 {
+		s.limits.slots = make(chan struct{}, s.MaxConnections)
+	}
+}
+
+// waitForSlot blocks in OverloadBlock mode until a connection may be
+// accepted. It returns false if the server closes meanwhile.
+func (s *Server) waitForSlot() bool {
+	if s.limits.slots == nil || s.OverloadMode != OverloadBlock {
+		return true
+	}
+	select {
+	case s.limits.slots <- struct{}{}:
+		return true
+	case <-s.closed:
+		return false
+	}
+}
+
+// returnSlot undoes waitForSlot when no connection was accepted.
+func (s *Server) returnSlot() {
+	if s.limits.slots != nil && s.OverloadMode == OverloadBlock {
+		<-s.limits.slots
+	}
+}
+
+// admit takes the remaining limits for an accepted connection. If it
+// returns false the connection holds nothing and must be rejected.
+func (s *Server) admit(conn net.Conn) bool {
+	blocking := s.OverloadMode == OverloadBlock
+	if s.limits.slots != nil && !blocking {
+		select {
+		case s.limits.slots <- struct{}{}:
+		default:
+			return false
+		}
+	}
+
+	if s.MaxConnectionsPerIP > 0 {
+		ip := remoteIP(conn)
+		s.limits.mu.Lock()
+		allowed := s.limits.perIP[ip] < s.MaxConnectionsPerIP
+		if allowed {
+			s.limits.perIP[ip]++
+		}
+		s.limits.mu.Unlock()
+		if !allowed {
+			if s.limits.slots != nil {
+				<-s.limits.slots
+			}
+			return false
+		}
+	}
+	return true
+}
+
+// release gives back what admit and waitForSlot took for conn.
+func (s *Server) release(conn net.Conn) {
+	if s.MaxConnectionsPerIP > 0 {
+		ip := remoteIP(conn)
+		s.limits.mu.Lock()
+		s.limits.perIP[ip]--
+		if s.limits.perIP[ip] == 0 {
+			delete(s.limits.perIP, ip)
+		}
+		s.limits.mu.Unlock()
+	}
+	if s.limits.slots != nil {
+		<-s.limits.slots
+	}
+}
+
+// reject answers a connection over a limit without reading its request.
+func (s *Server) reject(conn net.Conn) {
+	defer s.metrics.connClosed()
+	defer closeConn(conn)
+
+	w := &response.Writer{
+		State:    response.WritingInitialised,
+		IoWriter: conn,
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(overloadRetryAfter))
+	w.WriteError(nil, response.ServiceUnavailable, response.Problem{
+		Detail: "The server is handling too many connections. Try again later.",
+	})
+}
+
+func remoteIP(conn net.Conn) string {
+	host, _, err := net.SplitHostPort(conn.RemoteAddr().String())
+	if err != nil {
+		return conn.RemoteAddr().String()
+	}
+	return host
+}
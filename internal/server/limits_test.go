@@ -0,0 +1,90 @@
+package server
+
+import (
+	"net"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/5tuartw/httpfromtcp/internal/request"
+	"github.com/5tuartw/httpfromtcp/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func okHandler(w *response.Writer, req *request.Request) {
+	w.WriteStatusLine(response.OK)
+	w.WriteHeaders(response.GetDefaultHeaders(2))
+	w.WriteBody([]byte("ok"))
+}
+
+// openConn returns a kept-alive connection that has been served once, so
+// it is certain to hold its connection slot.
+func openConn(t *testing.T, addr net.Addr) net.Conn {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr.String())
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	_, err = conn.Write([]byte("GET / HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	require.NoError(t, err)
+
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	var out []byte
+	buf := make([]byte, 256)
+	for !strings.HasSuffix(string(out), "\r\n\r\nok") {
+		n, err := conn.Read(buf)
+		require.NoError(t, err)
+		out = append(out, buf[:n]...)
+	}
+	return conn
+}
+
+func TestMaxConnectionsBlock(t *testing.T) {
+	addr := startServer(t, okHandler, WithMaxConnections(1, OverloadBlock))
+	first := openConn(t, addr)
+
+	// Test: the second connection waits in the backlog
+	second, err := net.Dial("tcp", addr.String())
+	require.NoError(t, err)
+	defer second.Close()
+	_, err = second.Write([]byte("GET / HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n\r\n"))
+	require.NoError(t, err)
+	second.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	_, err = second.Read(make([]byte, 1))
+	assert.ErrorIs(t, err, os.ErrDeadlineExceeded)
+
+	// Test: it is served once the first closes
+	first.Close()
+	second.SetReadDeadline(time.Now().Add(2 * time.Second))
+	buf := make([]byte, 64)
+	n, err := second.Read(buf)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(string(buf[:n]), "HTTP/1.1 200 OK\r\n"), string(buf[:n]))
+}
+
+func TestMaxConnectionsReject(t *testing.T) {
+	addr := startServer(t, okHandler, WithMaxConnections(1, OverloadReject))
+	openConn(t, addr)
+
+	// Test: over the limit gets 503 with Retry-After
+	out := roundTrip(t, addr, "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 503 Service Unavailable\r\n"), out)
+	assert.Contains(t, out, "Retry-After: 5\r\n")
+	assert.Contains(t, out, "Connection: close\r\n")
+}
+
+func TestMaxConnectionsPerIP(t *testing.T) {
+	addr := startServer(t, okHandler, WithMaxConnectionsPerIP(1))
+	first := openConn(t, addr)
+
+	out := roundTrip(t, addr, "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 503 Service Unavailable\r\n"), out)
+
+	// Test: the count is released when the connection closes
+	first.Close()
+	require.Eventually(t, func() bool {
+		out := roundTrip(t, addr, "GET / HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n\r\n")
+		return strings.HasPrefix(out, "HTTP/1.1 200 OK\r\n")
+	}, 2*time.Second, 50*time.Millisecond)
+}
@@ -0,0 +1,102 @@
+package server
+
+import (
+	"strings"
+	"time"
+
+	"github.com/5tuartw/httpfromtcp/internal/metrics"
+	"github.com/5tuartw/httpfromtcp/internal/request"
+	"github.com/5tuartw/httpfromtcp/internal/response"
+)
+
+// serverMetrics are the built-in metrics. A nil *serverMetrics records
+// nothing, so the server calls its methods unconditionally.
+type serverMetrics struct {
+	path string
+
+	accepted    *metrics.Counter
+	active      *metrics.Gauge
+	rejected    *metrics.Counter
+	requests    *metrics.CounterVec
+	parseErrors *metrics.Counter
+	duration    *metrics.Histogram
+}
+
+// WithMetrics records connection and request metrics in s.Metrics and serves
+// them in the Prometheus text format to GET requests for path, ahead of the
+// handler. Applications may register their own metrics in s.Metrics too.
+func WithMetrics(path string) Option {
+	return func(s *Server) {
+		if s.Metrics == nil {
+			s.Metrics = metrics.NewRegistry()
+		}
+		r := s.Metrics
+		s.metrics = &serverMetrics{
+			path:        path,
+			accepted:    r.NewCounter("http_connections_accepted_total", "Connections accepted."),
+			active:      r.NewGauge("http_connections_active", "Connections currently open."),
+			rejected:    r.NewCounter("http_connections_rejected_total", "Connections answered 503 for being over a connection limit."),
+			requests:    r.NewCounterVec("http_requests_total", "Requests served, by response status class.", "class"),
+			parseErrors: r.NewCounter("http_parse_errors_total", "Requests that could not be parsed."),
+			duration:    r.NewHistogram("http_request_duration_seconds", "Time from a parsed request to its handler returning."),
+		}
+	}
+}
+
+func (m *serverMetrics) connOpened() {
+	if m != nil {
+		m.accepted.Inc()
+		m.active.Inc()
+	}
+}
+
+func (m *serverMetrics) connClosed() {
+	if m != nil {
+		m.active.Dec()
+	}
+}
+
+func (m *serverMetrics) connRejected() {
+	if m != nil {
+		m.rejected.Inc()
+	}
+}
+
+func (m *serverMetrics) parseError() {
+	if m != nil {
+		m.parseErrors.Inc()
+	}
+}
+
+func (m *serverMetrics) requestServed(status response.StatusCode, d time.Duration) {
+	if m == nil {
+		return
+	}
+	class := status.Class()
+	if class == "" {
+		// The handler wrote no response
+		class = "none"
+	}
+	m.requests.With(class).Inc()
+	m.duration.Observe(d.Seconds())
+}
+
+// isScrape reports whether req asks for the metrics page.
+func (m *serverMetrics) isScrape(req *request.Request) bool {
+	if m == nil {
+		return false
+	}
+	method := req.RequestLine.Method
+	return (method == "GET" || method == "HEAD") && requestPath(req) == m.path
+}
+
+func (s *Server) writeMetrics(w *response.Writer) {
+	var body strings.Builder
+	s.Metrics.WriteText(&body)
+	h := response.GetDefaultHeaders(body.Len())
+	h.Set("Content-Type", metrics.ContentType)
+	h.Set("Cache-Control", "no-store")
+	w.WriteStatusLine(response.OK)
+	w.WriteHeaders(h)
+	w.WriteBody([]byte(body.String()))
+}
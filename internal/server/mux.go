@@ -0,0 +1,121 @@
+package server
+
+import (
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/5tuartw/httpfromtcp/internal/request"
+	"github.com/5tuartw/httpfromtcp/internal/response"
+)
+
+// Mux routes requests to handlers by method and exact path. Pass its Serve
+// method to Serve as the server's Handler.
+type Mux struct {
+	mu     sync.RWMutex
+	routes map[string]map[string]Handler
+}
+
+func NewMux() *Mux {
+	return &Mux{routes: map[string]map[string]Handler{}}
+}
+
+// Handle registers h for method and path, replacing any earlier handler. A
+// GET handler also answers HEAD unless a HEAD handler is registered.
+func (m *Mux) Handle(method, path string, h Handler) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.routes[path] == nil {
+		m.routes[path] = map[string]Handler{}
+	}
+	m.routes[path][method] = h
+}
+
+// Serve dispatches req, answering 404 for unknown paths and 405 with an
+// Allow header for methods the path has no handler for. OPTIONS is answered
+// with the allowed methods of the path, or of the whole server for "*",
+// unless a handler is registered for it.
+func (m *Mux) Serve(w *response.Writer, req *request.Request) {
+	method := req.RequestLine.Method
+	if method == "OPTIONS" && req.RequestLine.RequestTarget == "*" {
+		w.Header().Set("Allow", m.serverMethods())
+		w.WriteStatusLine(response.NoContent)
+		w.WriteHeaders(nil)
+		return
+	}
+
+	m.mu.RLock()
+	methods := m.routes[requestPath(req)]
+	h, ok := methods[method]
+	if !ok && method == "HEAD" {
+		h, ok = methods["GET"]
+	}
+	allow := allowedMethods(methods)
+	m.mu.RUnlock()
+
+	switch {
+	case ok:
+		h(w, req)
+	case len(methods) == 0:
+		w.WriteError(req, response.NotFound, response.Problem{})
+	case method == "OPTIONS":
+		w.Header().Set("Allow", allow)
+		w.WriteStatusLine(response.NoContent)
+		w.WriteHeaders(nil)
+	default:
+		w.Header().Set("Allow", allow)
+		w.WriteError(req, response.MethodNotAllowed, response.Problem{
+			Detail: method + " is not supported here; allowed methods are " + allow + ".",
+		})
+	}
+}
+
+// serverMethods lists every method any path supports.
+func (m *Mux) serverMethods() string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	all := map[string]Handler{}
+	for _, methods := range m.routes {
+		for method, h := range methods {
+			all[method] = h
+		}
+	}
+	return allowedMethods(all)
+}
+
+// allowedMethods lists the registered methods with the HEAD and OPTIONS the
+// mux answers itself.
+func allowedMethods(methods map[string]Handler) string {
+	set := map[string]bool{"OPTIONS": true}
+	for method := range methods {
+		set[method] = true
+	}
+	if set["GET"] {
+		set["HEAD"] = true
+	}
+	allowed := make([]string, 0, len(set))
+	for method := range set {
+		allowed = append(allowed, method)
+	}
+	sort.Strings(allowed)
+	return strings.Join(allowed, ", ")
+}
+
+// requestPath returns the path of an origin-form or absolute-form target,
+// without its query.
+func requestPath(req *request.Request) string {
+	target := req.RequestLine.RequestTarget
+	if !strings.HasPrefix(target, "/") {
+		u, err := url.Parse(target)
+		if err != nil {
+			return ""
+		}
+		target = u.Path
+		if target == "" {
+			target = "/"
+		}
+	}
+	path, _, _ := strings.Cut(target, "?")
+	return path
+}
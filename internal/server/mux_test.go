@@ -0,0 +1,66 @@
+package server
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/5tuartw/httpfromtcp/internal/request"
+	"github.com/5tuartw/httpfromtcp/internal/response"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMux(t *testing.T) {
+	mux := NewMux()
+	mux.Handle("GET", "/status", func(w *response.Writer, req *request.Request) {
+		body := []byte("all good")
+		w.WriteStatusLine(response.OK)
+		w.WriteHeaders(response.GetDefaultHeaders(len(body)))
+		w.WriteBody(body)
+	})
+	mux.Handle("POST", "/status", func(w *response.Writer, req *request.Request) {
+		w.WriteStatusLine(response.NoContent)
+		w.WriteHeaders(nil)
+	})
+	addr := startServer(t, mux.Serve)
+
+	// Test: GET with a query string
+	out := roundTrip(t, addr, "GET /status?verbose=1 HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n\r\n")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 200 OK\r\n"), out)
+	assert.True(t, strings.HasSuffix(out, "all good"), out)
+
+	// Test: HEAD falls back to GET, keeps Content-Length and sends no body,
+	// and the connection stays usable for the next request
+	out = roundTrip(t, addr,
+		"HEAD /status HTTP/1.1\r\nHost: localhost\r\n\r\n"+
+			"GET /status HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n\r\n")
+	head, get, found := strings.Cut(out, "\r\n\r\nHTTP/1.1 ")
+	assert.True(t, found, out)
+	assert.Contains(t, head, "Content-Length: 8")
+	assert.NotContains(t, head, "all good")
+	assert.True(t, strings.HasSuffix(get, "\r\n\r\nall good"), out)
+
+	// Test: unknown path and unsupported method
+	out = roundTrip(t, addr, "GET /missing HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n\r\n")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 404 Not Found\r\n"), out)
+	out = roundTrip(t, addr, "DELETE /status HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n\r\n")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 405 Method Not Allowed\r\n"), out)
+	assert.Contains(t, out, "Allow: GET, HEAD, OPTIONS, POST\r\n")
+}
+
+func TestMuxOptions(t *testing.T) {
+	mux := NewMux()
+	noop := func(w *response.Writer, req *request.Request) {}
+	mux.Handle("GET", "/a", noop)
+	mux.Handle("DELETE", "/b", noop)
+	addr := startServer(t, mux.Serve)
+
+	// Test: OPTIONS * lists every method the server supports
+	out := roundTrip(t, addr, "OPTIONS * HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n\r\n")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 204 No Content\r\n"), out)
+	assert.Contains(t, out, "Allow: DELETE, GET, HEAD, OPTIONS\r\n")
+
+	// Test: OPTIONS on a path lists its methods
+	out = roundTrip(t, addr, "OPTIONS /b HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n\r\n")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 204 No Content\r\n"), out)
+	assert.Contains(t, out, "Allow: DELETE, OPTIONS\r\n")
+}
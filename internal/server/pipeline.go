@@ -0,0 +1,152 @@
+package server
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	maxPipelinedRequests = 16
+	// maxBufferedResponse caps what a response behind the head of the queue
+	// holds in memory; its handler blocks until it reaches the head.
+	maxBufferedResponse = 64 << 10
+)
+
+var errConnectionClosing = errors.New("connection is closing")
+
+// pipeline hands out one responseSlot per request read from a connection and
+// makes sure responses reach the client in request order. The slot at the
+// head of the queue writes straight to the connection; the others buffer
+// until every earlier response is finished, or block once they have buffered
+// maxBufferedResponse bytes.
+type pipeline struct {
+	mu      sync.Mutex
+	moved   *sync.Cond
+	conn    net.Conn
+	slots   []*responseSlot
+	closing bool
+	pending sync.WaitGroup
+	limit   chan struct{}
+}
+
+type responseSlot struct {
+	p        *pipeline
+	buf      bytes.Buffer
+	done     bool
+	reusable bool
+}
+
+func newPipeline(conn net.Conn) *pipeline {
+	p := &pipeline{
+		conn:  conn,
+		limit: make(chan struct{}, maxPipelinedRequests),
+	}
+	p.moved = sync.NewCond(&p.mu)
+	return p
+}
+
+// reserve queues a slot for the next response, blocking while too many
+// requests are already being handled.
+func (p *pipeline) reserve() *responseSlot {
+	p.limit <- struct{}{}
+	p.pending.Add(1)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	slot := &responseSlot{p: p}
+	p.slots = append(p.slots, slot)
+	return slot
+}
+
+// finish marks the slot's response as complete. Once it reaches the head of
+// the queue the connection is closed unless reusable is set.
+func (p *pipeline) finish(slot *responseSlot, reusable bool) {
+	p.mu.Lock()
+	slot.done = true
+	slot.reusable = reusable
+	p.advance()
+	p.mu.Unlock()
+
+	<-p.limit
+	p.pending.Done()
+}
+
+// advance drops finished slots from the head of the queue and flushes what
+// the new head has buffered so far. Callers hold p.mu.
+func (p *pipeline) advance() {
+	if len(p.slots) > 0 && p.slots[0].done {
+		defer p.moved.Broadcast()
+	}
+	for len(p.slots) > 0 && p.slots[0].done {
+		head := p.slots[0]
+		p.slots = p.slots[1:]
+		if !head.reusable {
+			p.close()
+		}
+		if len(p.slots) > 0 && !p.closing {
+			_, err := p.conn.Write(p.slots[0].buf.Bytes())
+			p.slots[0].buf.Reset()
+			if err != nil {
+				p.close()
+			}
+		}
+	}
+}
+
+// close stops any further responses being sent and interrupts the read loop.
+// Callers hold p.mu.
+func (p *pipeline) close() {
+	if p.closing {
+		return
+	}
+	p.closing = true
+	for _, slot := range p.slots {
+		slot.buf.Reset()
+	}
+	p.moved.Broadcast()
+	p.conn.SetReadDeadline(time.Now())
+}
+
+// prepareRead sets the deadline for reading the next request and reports
+// whether the connection is still accepting requests.
+func (p *pipeline) prepareRead(idleTimeout time.Duration) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closing {
+		return false
+	}
+	if idleTimeout > 0 {
+		p.conn.SetReadDeadline(time.Now().Add(idleTimeout))
+	} else {
+		p.conn.SetReadDeadline(time.Time{})
+	}
+	return true
+}
+
+// wait blocks until every reserved slot has finished.
+func (p *pipeline) wait() {
+	p.pending.Wait()
+}
+
+func (slot *responseSlot) Write(b []byte) (int, error) {
+	p := slot.p
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for !p.closing && p.slots[0] != slot && slot.buf.Len()+len(b) > maxBufferedResponse {
+		p.moved.Wait()
+	}
+	if p.closing {
+		return 0, errConnectionClosing
+	}
+	if p.slots[0] != slot {
+		return slot.buf.Write(b)
+	}
+	n, err := p.conn.Write(b)
+	if err != nil {
+		p.close()
+	}
+	return n, err
+}
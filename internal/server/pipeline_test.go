@@ -0,0 +1,55 @@
+package server
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPipelineBuffering(t *testing.T) {
+	serverConn, clientConn := net.Pipe()
+	defer clientConn.Close()
+	received := make(chan []byte)
+	go func() {
+		out, _ := io.ReadAll(clientConn)
+		received <- out
+	}()
+
+	p := newPipeline(serverConn)
+	first := p.reserve()
+	second := p.reserve()
+
+	// Test: a response behind the head blocks once its buffer is full
+	body := bytes.Repeat([]byte("b"), 2*maxBufferedResponse)
+	written := make(chan error)
+	go func() {
+		_, err := second.Write(body[:maxBufferedResponse])
+		if err == nil {
+			_, err = second.Write(body[maxBufferedResponse:])
+		}
+		written <- err
+	}()
+	select {
+	case <-written:
+		t.Fatal("write past the buffer limit did not block")
+	case <-time.After(50 * time.Millisecond):
+	}
+	p.mu.Lock()
+	assert.Equal(t, maxBufferedResponse, second.buf.Len())
+	p.mu.Unlock()
+
+	// Test: and continues once the earlier response is finished
+	_, err := first.Write([]byte("a"))
+	require.NoError(t, err)
+	p.finish(first, true)
+	require.NoError(t, <-written)
+	p.finish(second, false)
+	p.wait()
+	serverConn.Close()
+	assert.Equal(t, append([]byte("a"), body...), <-received)
+}
@@ -0,0 +1,33 @@
+package server
+
+import (
+	"crypto/tls"
+	"net"
+	"net/netip"
+
+	"github.com/5tuartw/httpfromtcp/internal/proxyproto"
+)
+
+// WithProxyProtocol expects a PROXY protocol v1 or v2 header on connections
+// from the trusted peers, such as a load balancer, and uses the client
+// address it carries as the connection's remote address. Connections from
+// other peers are served as they are.
+func WithProxyProtocol(trusted ...netip.Prefix) Option {
+	return func(s *Server) {
+		s.ProxyProtocol = trusted
+	}
+}
+
+// readProxyHeader reads the PROXY header of a connection from a trusted
+// peer, before any deadlines are set on it.
+func readProxyHeader(conn net.Conn) error {
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		conn = tlsConn.NetConn()
+	}
+	proxyConn, ok := conn.(*proxyproto.Conn)
+	if !ok {
+		return nil
+	}
+	_, err := proxyConn.Header()
+	return err
+}
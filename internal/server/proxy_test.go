@@ -0,0 +1,35 @@
+package server
+
+import (
+	"net/netip"
+	"strings"
+	"testing"
+
+	"github.com/5tuartw/httpfromtcp/internal/request"
+	"github.com/5tuartw/httpfromtcp/internal/response"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProxyProtocol(t *testing.T) {
+	remoteAddrs := make(chan string, 1)
+	handler := func(w *response.Writer, req *request.Request) {
+		remoteAddrs <- req.RemoteAddr
+		okHandler(w, req)
+	}
+
+	// Test: a trusted peer's header sets the client address
+	addr := startServer(t, handler, WithProxyProtocol(netip.MustParsePrefix("127.0.0.0/8"), netip.MustParsePrefix("::1/128")))
+	out := roundTrip(t, addr, "PROXY TCP4 203.0.113.7 192.0.2.1 40000 80\r\nGET / HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n\r\n")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 200 OK\r\n"), out)
+	assert.Equal(t, "203.0.113.7:40000", <-remoteAddrs)
+
+	// Test: a trusted peer must send the header
+	out = roundTrip(t, addr, "GET / HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n\r\n")
+	assert.Empty(t, out)
+
+	// Test: an untrusted peer cannot spoof its address
+	addr = startServer(t, handler, WithProxyProtocol(netip.MustParsePrefix("10.0.0.0/8")))
+	out = roundTrip(t, addr, "PROXY TCP4 203.0.113.7 192.0.2.1 40000 80\r\nGET / HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n\r\n")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 400 Bad Request\r\n"), out)
+	assert.Empty(t, remoteAddrs)
+}
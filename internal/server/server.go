@@ -1,33 +1,110 @@
 package server
 
 import (
+	"context"
+	"crypto/tls"
 	"errors"
 	"fmt"
+	"io"
 	"log"
 	"net"
+	"net/netip"
+	"os"
+	"sync"
 	"sync/atomic"
+	"syscall"
+	"time"
 
+	"github.com/5tuartw/httpfromtcp/internal/accesslog"
+	"github.com/5tuartw/httpfromtcp/internal/metrics"
+	"github.com/5tuartw/httpfromtcp/internal/proxyproto"
 	"github.com/5tuartw/httpfromtcp/internal/request"
 	"github.com/5tuartw/httpfromtcp/internal/response"
 )
 
+const (
+	defaultIdleTimeout = 60 * time.Second
+	lingerTimeout      = 500 * time.Millisecond
+	maxLingerBytes     = 256 << 10
+	// maxDiscardBytes is how much unread body is skipped to keep a
+	// connection open rather than closing it.
+	maxDiscardBytes = 256 << 10
+)
+
 type Server struct {
-	Port       int
-	Listener   net.Listener
-	ServerOpen atomic.Bool
-	Handler    Handler
+	Port        int
+	Listener    net.Listener
+	ServerOpen  atomic.Bool
+	Handler     Handler
+	IdleTimeout time.Duration
+	AccessLog   accesslog.Sink
+	// Metrics is set by WithMetrics.
+	Metrics             *metrics.Registry
+	MaxConnections      int
+	OverloadMode        OverloadMode
+	MaxConnectionsPerIP int
+	// TLSConfig, when set, makes the server accept TLS connections only.
+	TLSConfig *tls.Config
+	// ProxyProtocol lists the peers trusted to send a PROXY header.
+	ProxyProtocol []netip.Prefix
+
+	metrics    *serverMetrics
+	nextConnID atomic.Uint64
+	limits     connLimits
+	closed     chan struct{}
+	// ctx is the parent of every request context, cancelled by Close.
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+type Option func(*Server)
+
+// WithIdleTimeout sets how long a kept-alive connection may wait for its next
+// request before it is closed. Zero disables the timeout.
+func WithIdleTimeout(d time.Duration) Option {
+	return func(s *Server) {
+		s.IdleTimeout = d
+	}
+}
+
+// WithAccessLog sends an entry to sink for every request a handler served.
+func WithAccessLog(sink accesslog.Sink) Option {
+	return func(s *Server) {
+		s.AccessLog = sink
+	}
+}
+
+// WithTLS serves TLS connections with config, which must hold at least one
+// certificate.
+func WithTLS(config *tls.Config) Option {
+	return func(s *Server) {
+		s.TLSConfig = config
+	}
 }
 
-func Serve(port int, h Handler) (*Server, error) {
+func Serve(port int, h Handler, opts ...Option) (*Server, error) {
 	listener, err := net.Listen("tcp", ":"+fmt.Sprint(port))
 	if err != nil {
 		return nil, err
 	}
 	server := Server{
-		Port:     port,
-		Listener: listener,
-		Handler:  h,
+		Port:        port,
+		Listener:    listener,
+		Handler:     h,
+		IdleTimeout: defaultIdleTimeout,
+	}
+	for _, opt := range opts {
+		opt(&server)
+	}
+	if len(server.ProxyProtocol) > 0 {
+		server.Listener = &proxyproto.Listener{Listener: server.Listener, Trusted: server.ProxyProtocol}
+	}
+	if server.TLSConfig != nil {
+		server.Listener = tls.NewListener(server.Listener, server.TLSConfig)
 	}
+	server.closed = make(chan struct{})
+	server.ctx, server.cancel = context.WithCancel(context.Background())
+	server.initLimits()
 	server.ServerOpen.Store(true)
 	go server.listen()
 	return &server, nil
@@ -39,13 +116,16 @@ func (s *Server) Close() error {
 		return err
 	}
 	s.ServerOpen.Store(false)
+	close(s.closed)
+	s.cancel()
 	return nil
 }
 
 func (s *Server) listen() {
-	for s.ServerOpen.Load() {
+	for s.ServerOpen.Load() && s.waitForSlot() {
 		connection, err := s.Listener.Accept()
 		if err != nil {
+			s.returnSlot()
 			if errors.Is(err, net.ErrClosed) {
 				break
 			} else {
@@ -53,27 +133,239 @@ func (s *Server) listen() {
 				continue
 			}
 		}
-		go s.handle(connection)
+		s.metrics.connOpened()
+		go s.serveConn(connection)
 	}
 }
 
-func (s *Server) handle(conn net.Conn) {
-	req, err := request.RequestFromReader(conn)
+// serveConn applies the connection limits, then handles the connection.
+func (s *Server) serveConn(conn net.Conn) {
+	// Limits must see the client address from the PROXY header
+	err := readProxyHeader(conn)
 	if err != nil {
-		log.Printf("Could not parse request: %v", err)
+		log.Printf("Could not read PROXY header: %v", err)
+		s.returnSlot()
+		s.metrics.connClosed()
 		conn.Close()
 		return
 	}
+	if !s.admit(conn) {
+		s.metrics.connRejected()
+		s.reject(conn)
+		return
+	}
+	s.handle(conn)
+}
+
+func (s *Server) handle(conn net.Conn) {
+	defer s.metrics.connClosed()
+	defer s.release(conn)
+	defer closeConn(conn)
+	// The lingering close must not read alongside the watcher
+	var watcher sync.WaitGroup
+	defer watcher.Wait()
+
+	// Cancelled once the client is known to have gone
+	ctx, cancel := context.WithCancel(s.ctx)
+	defer cancel()
+
+	reader := request.NewReader(conn)
+	p := newPipeline(conn)
+	defer p.wait()
+
+	connID := s.nextConnID.Add(1)
+	sequence := 0
+	for p.prepareRead(s.IdleTimeout) {
+		req, err := reader.Next()
+		if err != nil {
+			if disconnected(err) {
+				cancel()
+				return
+			}
+			// Idle keep-alive connections time out without a response
+			if errors.Is(err, os.ErrDeadlineExceeded) && !errors.Is(err, request.ErrRequestTimeout) {
+				return
+			}
+			log.Printf("Could not parse request: %v", err)
+			s.metrics.parseError()
+			s.writeParseError(p, err)
+			return
+		}
+		conn.SetReadDeadline(time.Time{})
+		sequence++
+		req.RemoteAddr = conn.RemoteAddr().String()
+		req.LocalAddr = conn.LocalAddr().String()
+		req.ConnID = connID
+		req.Sequence = sequence
+		req.ClientAddr = remoteIP(conn)
+		req.Scheme = "http"
+		req.Host = req.Headers.Get("Host")
+		if tlsConn, ok := conn.(*tls.Conn); ok {
+			// The handshake is done by the first read
+			state := tlsConn.ConnectionState()
+			req.TLS = &state
+			req.Scheme = "https"
+		}
+
+		keepAlive := req.KeepAlive() && s.ServerOpen.Load()
+		slot := p.reserve()
+		w := &response.Writer{
+			State:          response.WritingInitialised,
+			IoWriter:       slot,
+			RequestVersion: req.RequestLine.HttpVersion,
+			KeepAlive:      keepAlive,
+			DiscardBody:    req.RequestLine.Method == "HEAD",
+		}
 
+		if unsupportedExpectation(req) {
+			w.KeepAlive = false
+			w.WriteError(req, response.ExpectationFailed, response.Problem{
+				Detail: "Only the 100-continue expectation is supported.",
+			})
+			p.finish(slot, false)
+			return
+		}
+		req.SetContinueHandler(func() error {
+			// Once a final response has started the client must not get a 100
+			if w.State != response.WritingInitialised {
+				return nil
+			}
+			return w.WriteInformational(response.Continue, nil)
+		})
+
+		if req.BodyPending() {
+			// The handler reads the body from the connection, so nothing
+			// else can be parsed until it is done with it
+			stop := watchAfterBody(conn, reader, req, cancel)
+			s.serve(ctx, p, slot, w, req)
+			stop()
+			if req.BodyPending() {
+				return
+			}
+		} else {
+			go s.serve(ctx, p, slot, w, req)
+		}
+
+		if !keepAlive {
+			// Nothing more is parsed, but reading on still notices the
+			// client going away while the handler runs
+			watcher.Add(1)
+			go func() {
+				defer watcher.Done()
+				watchDisconnect(conn, cancel)
+			}()
+			return
+		}
+	}
+}
+
+func (s *Server) serve(ctx context.Context, p *pipeline, slot *responseSlot, w *response.Writer, req *request.Request) {
+	ctx, cancel := context.WithCancel(ctx)
+	req.SetContext(ctx)
+	start := time.Now()
+	if s.metrics.isScrape(req) {
+		s.writeMetrics(w)
+	} else {
+		s.Handler(w, req)
+	}
+	duration := time.Since(start)
+	cancel()
+	s.metrics.requestServed(w.Status(), duration)
+	// An unread body would be parsed as the next request
+	p.finish(slot, w.Reusable() && req.DiscardBody(maxDiscardBytes))
+	if s.AccessLog != nil {
+		s.AccessLog(accesslog.Entry{
+			RemoteAddr: req.RemoteAddr,
+			Time:       req.ReceivedAt,
+			Method:     req.RequestLine.Method,
+			Target:     req.RequestLine.RequestTarget,
+			Proto:      "HTTP/" + req.RequestLine.HttpVersion,
+			Status:     w.Status(),
+			Bytes:      w.BytesWritten(),
+			Duration:   duration,
+			Referer:    req.Headers.Get("Referer"),
+			UserAgent:  req.Headers.Get("User-Agent"),
+		})
+	}
+}
+
+// writeParseError answers a request that could not be parsed and leaves the
+// connection to be closed, since the next message boundary is unknown.
+func (s *Server) writeParseError(p *pipeline, err error) {
+	status := response.BadRequest
+	switch {
+	case errors.Is(err, request.ErrRequestTimeout):
+		status = response.RequestTimeout
+	case errors.Is(err, request.ErrUnsupportedVersion):
+		status = response.HTTPVersionNotSupported
+	case errors.Is(err, request.ErrUnsupportedTransferCoding):
+		status = response.NotImplemented
+	}
+
+	slot := p.reserve()
 	w := &response.Writer{
 		State:    response.WritingInitialised,
-		IoWriter: conn,
+		IoWriter: slot,
 	}
+	w.WriteError(nil, status, response.Problem{Detail: err.Error()})
+	p.finish(slot, false)
+}
 
-	s.Handler(w, req)
-
+// closeConn half-closes the connection and drains what the client already
+// sent before closing it, otherwise unread pipelined requests make the kernel
+// reset the connection before the client has read the last response.
+func closeConn(conn net.Conn) {
+	if halfCloser, ok := conn.(interface{ CloseWrite() error }); ok {
+		halfCloser.CloseWrite()
+		conn.SetReadDeadline(time.Now().Add(lingerTimeout))
+		io.Copy(io.Discard, io.LimitReader(conn, maxLingerBytes))
+	}
 	conn.Close()
 }
 
-type Handler func(w *response.Writer, req *request.Request)
+// disconnected reports whether a read error means the client closed or reset
+// the connection between requests.
+func disconnected(err error) bool {
+	return errors.Is(err, io.EOF) || errors.Is(err, syscall.ECONNRESET)
+}
+
+// watchDisconnect discards anything sent after the last request and cancels
+// the connection's context when the client closes it, or when the pipeline
+// interrupts the read once the last response is done.
+func watchDisconnect(conn net.Conn, cancel context.CancelFunc) {
+	io.Copy(io.Discard, conn)
+	cancel()
+}
 
+// watchAfterBody reads from the connection in the background once the
+// handler has read req's body, so the client going away still cancels the
+// connection's context. The returned stop interrupts the read; anything it
+// got is kept in reader for the next request.
+func watchAfterBody(conn net.Conn, reader *request.Reader, req *request.Request, cancel context.CancelFunc) (stop func()) {
+	var watcher sync.WaitGroup
+	req.SetBodyReadHandler(func() {
+		watcher.Add(1)
+		go func() {
+			defer watcher.Done()
+			if disconnected(reader.Fill()) {
+				cancel()
+			}
+		}()
+	})
+	return func() {
+		req.SetBodyReadHandler(nil)
+		conn.SetReadDeadline(time.Now())
+		watcher.Wait()
+	}
+}
+
+// unsupportedExpectation reports whether the request has an Expect value other
+// than 100-continue, which HTTP/1.0 requests are allowed to get away with.
+func unsupportedExpectation(req *request.Request) bool {
+	if req.RequestLine.HttpVersion == "1.0" {
+		return false
+	}
+	return req.Headers.Get("Expect") != "" && !req.ExpectsContinue()
+}
+
+type Handler func(w *response.Writer, req *request.Request)
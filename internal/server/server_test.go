@@ -0,0 +1,396 @@
+package server
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/5tuartw/httpfromtcp/internal/accesslog"
+	"github.com/5tuartw/httpfromtcp/internal/request"
+	"github.com/5tuartw/httpfromtcp/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func startServer(t *testing.T, h Handler, opts ...Option) net.Addr {
+	t.Helper()
+	s, err := Serve(0, h, opts...)
+	require.NoError(t, err)
+	t.Cleanup(func() { s.Close() })
+	return s.Listener.Addr()
+}
+
+func roundTrip(t *testing.T, addr net.Addr, data string) string {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr.String())
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = conn.Write([]byte(data))
+	require.NoError(t, err)
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	out, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	return string(out)
+}
+
+func TestPipelining(t *testing.T) {
+	addr := startServer(t, func(w *response.Writer, req *request.Request) {
+		// Earlier requests take longer so responses finish out of order
+		switch req.RequestLine.RequestTarget {
+		case "/1":
+			time.Sleep(60 * time.Millisecond)
+		case "/2":
+			time.Sleep(30 * time.Millisecond)
+		}
+		body := []byte("response " + req.RequestLine.RequestTarget)
+		w.WriteStatusLine(response.OK)
+		w.WriteHeaders(response.GetDefaultHeaders(len(body)))
+		w.WriteBody(body)
+	})
+
+	// Test: pipelined requests in one write are answered in order
+	out := roundTrip(t, addr,
+		"GET /1 HTTP/1.1\r\nHost: localhost\r\n\r\n"+
+			"POST /2 HTTP/1.1\r\nHost: localhost\r\nContent-Length: 4\r\n\r\nbody"+
+			"GET /3 HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n\r\n")
+	assert.Equal(t, 3, strings.Count(out, "HTTP/1.1 200 OK"))
+	first := strings.Index(out, "response /1")
+	second := strings.Index(out, "response /2")
+	third := strings.Index(out, "response /3")
+	require.True(t, first >= 0 && second >= 0 && third >= 0, out)
+	assert.True(t, first < second && second < third, out)
+	assert.True(t, strings.HasSuffix(out, "Connection: close\r\n\r\nresponse /3"), out)
+
+	// Test: nothing after a request asking to close is answered
+	out = roundTrip(t, addr,
+		"GET /1 HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n\r\n"+
+			"GET /2 HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	assert.Equal(t, 1, strings.Count(out, "HTTP/1.1 200 OK"))
+
+	// Test: HTTP/1.0 closes after one response by default
+	out = roundTrip(t, addr, "GET /3 HTTP/1.0\r\n\r\nGET /3 HTTP/1.0\r\n\r\n")
+	assert.Equal(t, 1, strings.Count(out, "HTTP/1.1 200 OK"))
+}
+
+func TestExpectContinue(t *testing.T) {
+	addr := startServer(t, func(w *response.Writer, req *request.Request) {
+		if req.RequestLine.RequestTarget == "/reject" {
+			w.WriteStatusLine(response.ContentTooLarge)
+			w.WriteHeaders(response.GetDefaultHeaders(0))
+			return
+		}
+		body, err := req.ReadBody()
+		require.NoError(t, err)
+		w.WriteStatusLine(response.OK)
+		w.WriteHeaders(response.GetDefaultHeaders(len(body)))
+		w.WriteBody(body)
+	})
+
+	// Test: 100 Continue is sent before the body is read
+	conn, err := net.Dial("tcp", addr.String())
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = conn.Write([]byte("POST /upload HTTP/1.1\r\nHost: localhost\r\nExpect: 100-continue\r\nContent-Length: 5\r\nConnection: close\r\n\r\n"))
+	require.NoError(t, err)
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	interim := make([]byte, len("HTTP/1.1 100 Continue\r\n\r\n"))
+	_, err = io.ReadFull(conn, interim)
+	require.NoError(t, err)
+	assert.Equal(t, "HTTP/1.1 100 Continue\r\n\r\n", string(interim))
+	_, err = conn.Write([]byte("hello"))
+	require.NoError(t, err)
+	out, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(string(out), "HTTP/1.1 200 OK\r\n"))
+	assert.True(t, strings.HasSuffix(string(out), "hello"))
+
+	// Test: early rejection without reading the body closes the connection
+	out2 := roundTrip(t, addr, "POST /reject HTTP/1.1\r\nHost: localhost\r\nExpect: 100-continue\r\nContent-Length: 5\r\n\r\n")
+	assert.True(t, strings.HasPrefix(out2, "HTTP/1.1 413 "), out2)
+	assert.NotContains(t, out2, "100 Continue")
+
+	// Test: unknown expectation
+	out2 = roundTrip(t, addr, "GET / HTTP/1.1\r\nHost: localhost\r\nExpect: something\r\n\r\n")
+	assert.True(t, strings.HasPrefix(out2, "HTTP/1.1 417 "), out2)
+}
+
+func TestUnreadBody(t *testing.T) {
+	addr := startServer(t, func(w *response.Writer, req *request.Request) {
+		w.WriteStatusLine(response.OK)
+		w.WriteHeaders(response.GetDefaultHeaders(0))
+	})
+
+	// Test: a small unread body is skipped and the connection reused
+	out := roundTrip(t, addr,
+		"POST / HTTP/1.1\r\nHost: localhost\r\nContent-Length: 5\r\n\r\nhello"+
+			"GET / HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n\r\n")
+	assert.Equal(t, 2, strings.Count(out, "HTTP/1.1 200 OK"), out)
+
+	// Test: a large one closes the connection instead
+	conn, err := net.Dial("tcp", addr.String())
+	require.NoError(t, err)
+	defer conn.Close()
+	size := 2 * maxDiscardBytes
+	go func() {
+		conn.Write([]byte(fmt.Sprintf("POST / HTTP/1.1\r\nHost: localhost\r\nContent-Length: %d\r\n\r\n", size)))
+		conn.Write(make([]byte, size))
+		conn.Write([]byte("GET / HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	}()
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	got, err := io.ReadAll(conn)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, strings.Count(string(got), "HTTP/1.1 200 OK"), string(got))
+}
+
+func TestParseErrors(t *testing.T) {
+	addr := startServer(t, func(w *response.Writer, req *request.Request) {
+		w.WriteStatusLine(response.OK)
+		w.WriteHeaders(response.GetDefaultHeaders(0))
+	})
+
+	// Test: malformed request gets problem details and the connection closes
+	out := roundTrip(t, addr, "GET / HTTP/1.1\r\nHost: localhost\r\nBad Header: x\r\n\r\n")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 400 Bad Request\r\n"), out)
+	assert.Contains(t, out, "Content-Type: application/problem+json\r\n")
+	assert.Contains(t, out, "Connection: close\r\n")
+	assert.Contains(t, out, `"status":400`)
+
+	// Test: unsupported version and transfer coding
+	out = roundTrip(t, addr, "GET / HTTP/2.0\r\nHost: localhost\r\n\r\n")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 505 HTTP Version Not Supported\r\n"), out)
+	out = roundTrip(t, addr, "POST / HTTP/1.1\r\nHost: localhost\r\nTransfer-Encoding: gzip\r\n\r\n")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 501 Not Implemented\r\n"), out)
+}
+
+func TestAccessLog(t *testing.T) {
+	entries := make(chan accesslog.Entry, 2)
+	addr := startServer(t, func(w *response.Writer, req *request.Request) {
+		body := []byte("hello")
+		w.WriteStatusLine(response.Created)
+		w.WriteHeaders(response.GetDefaultHeaders(len(body)))
+		w.WriteBody(body)
+	}, WithAccessLog(func(e accesslog.Entry) { entries <- e }))
+
+	// Test: status, size and request details are recorded per request
+	roundTrip(t, addr, "POST /items?x=1 HTTP/1.1\r\nHost: localhost\r\nUser-Agent: test\r\nContent-Length: 0\r\n\r\n"+
+		"HEAD /items HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n\r\n")
+	// Pipelined requests are handled concurrently, so either may log first
+	byMethod := map[string]accesslog.Entry{}
+	for range 2 {
+		e := <-entries
+		byMethod[e.Method] = e
+	}
+	e := byMethod["POST"]
+	assert.Equal(t, "/items?x=1", e.Target)
+	assert.Equal(t, "HTTP/1.1", e.Proto)
+	assert.Equal(t, response.Created, e.Status)
+	assert.Equal(t, 5, e.Bytes)
+	assert.Equal(t, "test", e.UserAgent)
+	assert.NotEmpty(t, e.RemoteAddr)
+	assert.Equal(t, 0, byMethod["HEAD"].Bytes)
+	assert.Equal(t, response.Created, byMethod["HEAD"].Status)
+}
+
+func TestMetrics(t *testing.T) {
+	addr := startServer(t, func(w *response.Writer, req *request.Request) {
+		w.WriteError(req, response.NotFound, response.Problem{})
+	}, WithMetrics("/metrics"))
+
+	roundTrip(t, addr, "GET /missing HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n\r\n")
+	roundTrip(t, addr, "GET / HTTP/1.1\r\nBad Header: x\r\n\r\n")
+
+	// Test: the scrape sees earlier connections, requests and parse errors
+	out := roundTrip(t, addr, "GET /metrics HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n\r\n")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 200 OK\r\n"), out)
+	assert.Contains(t, out, "Content-Type: text/plain; version=0.0.4; charset=utf-8\r\n")
+	assert.Contains(t, out, "\nhttp_connections_accepted_total 3\n")
+	// Earlier connections may still be lingering, so only the scrape's own is certain
+	assert.Regexp(t, `\nhttp_connections_active [1-3]\n`, out)
+	assert.Contains(t, out, "\nhttp_requests_total{class=\"4xx\"} 1\n")
+	assert.Contains(t, out, "\nhttp_parse_errors_total 1\n")
+	assert.Contains(t, out, "\nhttp_request_duration_seconds_count 1\n")
+}
+
+func TestConnectionMetadata(t *testing.T) {
+	requests := make(chan *request.Request, 3)
+	handler := func(w *response.Writer, req *request.Request) {
+		requests <- req
+		okHandler(w, req)
+	}
+	addr := startServer(t, handler)
+
+	// Test: requests on one connection share its ID and are numbered
+	roundTrip(t, addr, "GET /1 HTTP/1.1\r\nHost: localhost\r\n\r\nGET /2 HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n\r\n")
+	byTarget := map[string]*request.Request{}
+	for range 2 {
+		req := <-requests
+		byTarget[req.RequestLine.RequestTarget] = req
+	}
+	first, second := byTarget["/1"], byTarget["/2"]
+	assert.Equal(t, 1, first.Sequence)
+	assert.Equal(t, 2, second.Sequence)
+	assert.Equal(t, first.ConnID, second.ConnID)
+	assert.NotZero(t, first.ConnID)
+	_, port, _ := net.SplitHostPort(addr.String())
+	assert.True(t, strings.HasSuffix(first.LocalAddr, ":"+port), first.LocalAddr)
+	assert.NotEmpty(t, first.RemoteAddr)
+	assert.NotEqual(t, first.LocalAddr, first.RemoteAddr)
+	assert.False(t, first.ReceivedAt.IsZero())
+	assert.Nil(t, first.TLS)
+	assert.Equal(t, "http", first.Scheme)
+	assert.Equal(t, "localhost", first.Host)
+	remoteHost, _, _ := net.SplitHostPort(first.RemoteAddr)
+	assert.Equal(t, remoteHost, first.ClientAddr)
+
+	// Test: a new connection gets a new ID
+	roundTrip(t, addr, "GET /3 HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n\r\n")
+	third := <-requests
+	assert.Equal(t, 1, third.Sequence)
+	assert.NotEqual(t, first.ConnID, third.ConnID)
+}
+
+func TestTLS(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		DNSNames:     []string{"localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	cert := tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+
+	requests := make(chan *request.Request, 1)
+	addr := startServer(t, func(w *response.Writer, req *request.Request) {
+		requests <- req
+		okHandler(w, req)
+	}, WithTLS(&tls.Config{Certificates: []tls.Certificate{cert}}))
+
+	// Test: the handler sees the negotiated TLS state
+	conn, err := tls.Dial("tcp", addr.String(), &tls.Config{InsecureSkipVerify: true, ServerName: "localhost"})
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = conn.Write([]byte("GET / HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n\r\n"))
+	require.NoError(t, err)
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	out, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(string(out), "HTTP/1.1 200 OK\r\n"), string(out))
+
+	req := <-requests
+	require.NotNil(t, req.TLS)
+	assert.True(t, req.TLS.HandshakeComplete)
+	assert.Equal(t, "localhost", req.TLS.ServerName)
+	assert.Equal(t, "https", req.Scheme)
+}
+
+func TestRequestContext(t *testing.T) {
+	done := make(chan error, 1)
+	s, err := Serve(0, func(w *response.Writer, req *request.Request) {
+		_, err := req.ReadBody()
+		require.NoError(t, err)
+		<-req.Context().Done()
+		done <- req.Context().Err()
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() { s.Close() })
+
+	waitCancelled := func() {
+		t.Helper()
+		select {
+		case err := <-done:
+			assert.ErrorIs(t, err, context.Canceled)
+		case <-time.After(2 * time.Second):
+			t.Fatal("request context was not cancelled")
+		}
+	}
+	send := func(data string) net.Conn {
+		conn, err := net.Dial("tcp", s.Listener.Addr().String())
+		require.NoError(t, err)
+		_, err = conn.Write([]byte(data))
+		require.NoError(t, err)
+		return conn
+	}
+
+	// Test: closing a kept-alive connection cancels the running handler
+	conn := send("GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	time.Sleep(20 * time.Millisecond)
+	conn.Close()
+	waitCancelled()
+
+	// Test: so does closing the connection after a last request
+	conn = send("GET / HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n\r\n")
+	time.Sleep(20 * time.Millisecond)
+	conn.Close()
+	waitCancelled()
+
+	// Test: and after a body the handler has read
+	conn = send("POST / HTTP/1.1\r\nHost: localhost\r\nContent-Length: 2\r\n\r\nhi")
+	time.Sleep(20 * time.Millisecond)
+	conn.Close()
+	waitCancelled()
+
+	// Test: closing the server cancels requests still being handled
+	conn = send("GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	defer conn.Close()
+	time.Sleep(20 * time.Millisecond)
+	require.NoError(t, s.Close())
+	waitCancelled()
+}
+
+func TestRequestAfterBody(t *testing.T) {
+	addr := startServer(t, func(w *response.Writer, req *request.Request) {
+		body, err := req.ReadBody()
+		require.NoError(t, err)
+		if len(body) > 0 {
+			time.Sleep(50 * time.Millisecond)
+		}
+		w.WriteStatusLine(response.OK)
+		w.WriteHeaders(response.GetDefaultHeaders(len(body)))
+		w.WriteBody(body)
+	})
+
+	// Test: a request arriving while the previous handler still runs is
+	// parsed once it is done
+	conn, err := net.Dial("tcp", addr.String())
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = conn.Write([]byte("POST / HTTP/1.1\r\nHost: localhost\r\nContent-Length: 5\r\n\r\nhello"))
+	require.NoError(t, err)
+	time.Sleep(20 * time.Millisecond)
+	_, err = conn.Write([]byte("GET / HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n\r\n"))
+	require.NoError(t, err)
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	out, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	assert.Equal(t, 2, strings.Count(string(out), "HTTP/1.1 200 OK"), string(out))
+	assert.Contains(t, string(out), "hello")
+}
+
+func TestRequestContextEndsWithHandler(t *testing.T) {
+	contexts := make(chan context.Context, 1)
+	addr := startServer(t, func(w *response.Writer, req *request.Request) {
+		// Test: the context is live while the handler runs
+		assert.NoError(t, req.Context().Err())
+		contexts <- req.Context()
+		w.WriteStatusLine(response.OK)
+		w.WriteHeaders(response.GetDefaultHeaders(0))
+	})
+
+	out := roundTrip(t, addr, "GET / HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n\r\n")
+	assert.Contains(t, out, "HTTP/1.1 200 OK")
+	// Test: and cancelled once it has returned
+	assert.ErrorIs(t, (<-contexts).Err(), context.Canceled)
+}
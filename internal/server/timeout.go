@@ -0,0 +1,30 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/5tuartw/httpfromtcp/internal/request"
+	"github.com/5tuartw/httpfromtcp/internal/response"
+)
+
+// Timeout gives h's request context a deadline of d, for routes that call
+// slow downstream services. If h gives up without writing a response once
+// the deadline has passed, the client gets 503 Service Unavailable.
+func Timeout(d time.Duration, h Handler) Handler {
+	return func(w *response.Writer, req *request.Request) {
+		parent := req.Context()
+		ctx, cancel := context.WithTimeout(parent, d)
+		defer cancel()
+		req.SetContext(ctx)
+		h(w, req)
+		req.SetContext(parent)
+
+		if w.State == response.WritingInitialised && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			w.WriteError(req, response.ServiceUnavailable, response.Problem{
+				Detail: "The request took longer than " + d.String() + ".",
+			})
+		}
+	}
+}
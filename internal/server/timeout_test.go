@@ -0,0 +1,42 @@
+package server
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/5tuartw/httpfromtcp/internal/request"
+	"github.com/5tuartw/httpfromtcp/internal/response"
+	"github.com/stretchr/testify/assert"
+)
+
+type contextKey string
+
+func TestTimeout(t *testing.T) {
+	mux := NewMux()
+	mux.Handle("GET", "/slow", Timeout(20*time.Millisecond, func(w *response.Writer, req *request.Request) {
+		<-req.Context().Done()
+	}))
+	mux.Handle("GET", "/fast", Timeout(time.Second, func(w *response.Writer, req *request.Request) {
+		_, hasDeadline := req.Context().Deadline()
+		assert.True(t, hasDeadline)
+		body := []byte(req.Context().Value(contextKey("user")).(string))
+		w.WriteStatusLine(response.OK)
+		w.WriteHeaders(response.GetDefaultHeaders(len(body)))
+		w.WriteBody(body)
+	}))
+	addr := startServer(t, func(w *response.Writer, req *request.Request) {
+		req.SetContext(context.WithValue(req.Context(), contextKey("user"), "alice"))
+		mux.Serve(w, req)
+	})
+
+	// Test: a handler that gives up at the deadline gets a 503
+	out := roundTrip(t, addr, "GET /slow HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n\r\n")
+	assert.Contains(t, out, "HTTP/1.1 503 Service Unavailable")
+	assert.Contains(t, out, "took longer than 20ms")
+
+	// Test: values set by earlier middleware reach the handler
+	out = roundTrip(t, addr, "GET /fast HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n\r\n")
+	assert.Contains(t, out, "HTTP/1.1 200 OK")
+	assert.Contains(t, out, "\r\n\r\nalice")
+}